@@ -0,0 +1,32 @@
+package midterm
+
+import "time"
+
+// VisualBellDuration is how long the screen flashes when the bell rings and
+// VisualBell is enabled.
+const VisualBellDuration = 150 * time.Millisecond
+
+type OnBellFunc func()
+
+// OnBell sets a hook called every time the bell rings. The hook is called
+// once the Write which rang it has finished processing input, so it may use
+// the terminal.
+func (v *Terminal) OnBell(f OnBellFunc) {
+	v.mut.Lock()
+	v.onBell = f
+	v.mut.Unlock()
+}
+
+// Flashing reports whether the visual bell is currently flashing. Callers that
+// render the screen should render it again once it stops, VisualBellDuration
+// after LastBell.
+func (v *Terminal) Flashing() bool {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	return v.flashing()
+}
+
+func (v *Terminal) flashing() bool {
+	return v.VisualBell && !v.LastBell.IsZero() &&
+		time.Since(v.LastBell) < VisualBellDuration
+}
@@ -350,6 +350,31 @@ func (region *Region) String() string {
 	return fmt.Sprintf("%s:%d", region.F.Render(), region.Size)
 }
 
+// regionsOf builds a row of the given width from the format of each cell,
+// padding it with EmptyFormat.
+func regionsOf(formats []Format, width int) *Region {
+	head := &Region{Size: width}
+	region := head
+	for i, f := range formats {
+		if i == 0 {
+			region.F = f
+			region.Size = 0
+		} else if f != region.F {
+			region.Next = &Region{F: f}
+			region = region.Next
+		}
+		region.Size++
+	}
+	if pad := width - len(formats); pad > 0 && len(formats) > 0 {
+		if region.F == EmptyFormat {
+			region.Size += pad
+		} else {
+			region.Next = &Region{Size: pad}
+		}
+	}
+	return head
+}
+
 // ClearRow resets a row to a single region with EmptyFormat.
 func (canvas *Canvas) ClearRow(row int, format Format) {
 	if row >= len(canvas.Rows) {
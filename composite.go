@@ -0,0 +1,117 @@
+package midterm
+
+// Rect is a rectangle of cells.
+type Rect struct {
+	Row, Col      int
+	Height, Width int
+}
+
+// CompositeOptions configures how a terminal is drawn into another.
+type CompositeOptions struct {
+	// Source is the part of the source screen to draw. If it is empty, the
+	// whole screen is drawn.
+	Source Rect
+
+	// Cursor draws the source's cursor, if it is visible, as a reversed cell.
+	Cursor bool
+
+	// Border draws a box around the drawn cells, one cell outside of them, in
+	// BorderStyle.
+	Border      bool
+	BorderStyle Format
+
+	// Title is written into the top of the border, if there is one.
+	Title string
+}
+
+// Composite draws the screen of src into the screen at row and col, clipped
+// to its edges, so that layouts of panes can be rendered as one terminal.
+func (v *Terminal) Composite(src *Terminal, row, col int, opts CompositeOptions) {
+	src.mut.Lock()
+	r := opts.Source
+	if r.Height <= 0 || r.Width <= 0 {
+		r = Rect{Height: src.Height, Width: src.Width}
+	}
+	r = r.clip(Rect{Height: src.Height, Width: src.Width})
+	lines := make([]Line, r.Height)
+	for i := range lines {
+		lines[i] = src.line(r.Row + i)
+	}
+	cursor := Cursor{Y: -1, X: -1}
+	if opts.Cursor && src.CursorVisible {
+		cursor = src.Cursor
+	}
+	src.mut.Unlock()
+
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	for i, line := range lines {
+		for j := range r.Width {
+			c, f := ' ', EmptyFormat
+			if x := r.Col + j; x < len(line.Content) {
+				c, f = line.Content[x], line.Format[x]
+			}
+			if c == 0 {
+				c = ' '
+			}
+			if r.Row+i == cursor.Y && r.Col+j == cursor.X {
+				f.SetReverse(!f.IsReverse())
+			}
+			v.compositeCell(row+i, col+j, f, c)
+		}
+	}
+	if opts.Border {
+		v.compositeBorder(Rect{Row: row - 1, Col: col - 1, Height: r.Height + 2, Width: r.Width + 2}, opts.BorderStyle, opts.Title)
+	}
+}
+
+// compositeBorder draws a box along the edges of r, with the title in the top
+// edge.
+func (v *Terminal) compositeBorder(r Rect, f Format, title string) {
+	bottom, right := r.Row+r.Height-1, r.Col+r.Width-1
+	for x := r.Col + 1; x < right; x++ {
+		v.compositeCell(r.Row, x, f, '─')
+		v.compositeCell(bottom, x, f, '─')
+	}
+	for y := r.Row + 1; y < bottom; y++ {
+		v.compositeCell(y, r.Col, f, '│')
+		v.compositeCell(y, right, f, '│')
+	}
+	v.compositeCell(r.Row, r.Col, f, '┌')
+	v.compositeCell(r.Row, right, f, '┐')
+	v.compositeCell(bottom, r.Col, f, '└')
+	v.compositeCell(bottom, right, f, '┘')
+
+	// leave room for the corners, a line before the title and a space
+	// either side of it
+	room := r.Width - 5
+	if title == "" || room < 1 {
+		return
+	}
+	if runes := []rune(title); len(runes) > room {
+		title = string(runes[:room-1]) + "…"
+	}
+	x := r.Col + 2
+	for _, c := range " " + title + " " {
+		v.compositeCell(r.Row, x, f, c)
+		x++
+	}
+}
+
+// compositeCell paints a cell of the screen, unless it is out of bounds.
+func (v *Terminal) compositeCell(y, x int, f Format, c rune) {
+	if y < 0 || y >= v.Height || x < 0 || x >= v.Width {
+		return
+	}
+	v.paint(y, x, f, c)
+	v.MaxY = max(v.MaxY, y)
+	v.MaxX = max(v.MaxX, x)
+}
+
+// clip returns the part of r within bounds.
+func (r Rect) clip(bounds Rect) Rect {
+	top, left := max(r.Row, bounds.Row), max(r.Col, bounds.Col)
+	bottom := min(r.Row+r.Height, bounds.Row+bounds.Height)
+	right := min(r.Col+r.Width, bounds.Col+bounds.Width)
+	return Rect{Row: top, Col: left, Height: max(bottom-top, 0), Width: max(right-left, 0)}
+}
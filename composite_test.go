@@ -0,0 +1,116 @@
+package midterm_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vito/midterm"
+)
+
+func TestComposite(t *testing.T) {
+	t.Run("draws a terminal at an offset", func(t *testing.T) {
+		pane := midterm.NewTerminal(2, 4)
+		mustFprintf(t, pane, "ab\r\n\x1b[31mcd")
+
+		vt := midterm.NewTerminal(4, 8)
+		vt.Composite(pane, 1, 2, midterm.CompositeOptions{})
+		require.Equal(t, []string{
+			"",
+			"  ab",
+			"  cd",
+			"",
+		}, screenLines(vt))
+
+		var colored []bool
+		for region := range vt.Format.Regions(2) {
+			for range region.Size {
+				colored = append(colored, region.F.Fg != nil)
+			}
+		}
+		require.Equal(t, []bool{false, false, true, true, false, false, false, false}, colored)
+		require.Equal(t, 3, vt.UsedHeight())
+	})
+
+	t.Run("clips to the edges", func(t *testing.T) {
+		pane := midterm.NewTerminal(3, 4)
+		mustFprintf(t, pane, "abcd\r\nefgh\r\nijkl")
+
+		vt := midterm.NewTerminal(3, 6)
+		vt.Composite(pane, -1, 4, midterm.CompositeOptions{})
+		require.Equal(t, []string{
+			"    ef",
+			"    ij",
+			"",
+		}, screenLines(vt))
+	})
+
+	t.Run("draws a cropped rectangle", func(t *testing.T) {
+		pane := midterm.NewTerminal(3, 4)
+		mustFprintf(t, pane, "abcd\r\nefgh\r\nijkl")
+
+		vt := midterm.NewTerminal(2, 4)
+		vt.Composite(pane, 0, 0, midterm.CompositeOptions{
+			Source: midterm.Rect{Row: 1, Col: 1, Height: 5, Width: 2},
+		})
+		require.Equal(t, []string{"fg", "jk"}, screenLines(vt))
+	})
+
+	t.Run("draws borders and titles", func(t *testing.T) {
+		pane := midterm.NewTerminal(1, 6)
+		mustFprintf(t, pane, "hi")
+
+		vt := midterm.NewTerminal(3, 8)
+		vt.Composite(pane, 1, 1, midterm.CompositeOptions{
+			Border: true,
+			Title:  "sh",
+		})
+		require.Equal(t, []string{
+			"┌─ sh ─┐",
+			"│hi    │",
+			"└──────┘",
+		}, screenLines(vt))
+	})
+
+	t.Run("truncates titles too wide for the border", func(t *testing.T) {
+		pane := midterm.NewTerminal(1, 6)
+		vt := midterm.NewTerminal(3, 8)
+		vt.Composite(pane, 1, 1, midterm.CompositeOptions{
+			Border: true,
+			Title:  "bash -l",
+		})
+		require.Equal(t, "┌─ ba… ┐", screenLines(vt)[0])
+
+		pane = midterm.NewTerminal(1, 4)
+		vt = midterm.NewTerminal(3, 6)
+		vt.Composite(pane, 1, 1, midterm.CompositeOptions{
+			Border: true,
+			Title:  "bash",
+		})
+		require.Equal(t, "┌─ … ┐", screenLines(vt)[0])
+	})
+
+	t.Run("draws the cursor", func(t *testing.T) {
+		pane := midterm.NewTerminal(1, 4)
+		mustFprintf(t, pane, "\x1b[?25hab")
+
+		vt := midterm.NewTerminal(1, 4)
+		vt.Composite(pane, 0, 0, midterm.CompositeOptions{Cursor: true})
+		var reversed []bool
+		for region := range vt.Format.Regions(0) {
+			for range region.Size {
+				reversed = append(reversed, region.F.IsReverse())
+			}
+		}
+		require.Equal(t, []bool{false, false, true, false}, reversed)
+	})
+}
+
+// screenLines returns the rows of the screen with trailing blanks trimmed.
+func screenLines(vt *midterm.Terminal) []string {
+	var lines []string
+	for _, row := range vt.Content {
+		lines = append(lines, strings.TrimRight(string(row), " "))
+	}
+	return lines
+}
@@ -0,0 +1,56 @@
+package midterm
+
+import (
+	"net/url"
+)
+
+// WorkingDirectory is the working directory reported by the shell via OSC 7.
+type WorkingDirectory struct {
+	// Host is the host name of the machine the shell is running on. It may be
+	// empty, meaning the local machine.
+	Host string
+
+	// Path is the absolute path of the directory.
+	Path string
+}
+
+// URL returns the directory as a file:// URL, as sent in OSC 7.
+func (d WorkingDirectory) URL() string {
+	u := url.URL{Scheme: "file", Host: d.Host, Path: d.Path}
+	return u.String()
+}
+
+type OnCwdChangeFunc func(cwd WorkingDirectory)
+
+// OnCwdChange sets a hook called whenever the shell reports a new working
+// directory. The hook is called once the Write which reported it has finished
+// processing input, so it may use the terminal.
+func (v *Terminal) OnCwdChange(f OnCwdChangeFunc) {
+	v.mut.Lock()
+	v.onCwdChange = f
+	v.mut.Unlock()
+}
+
+// setCwd handles OSC 7 ; file://host/path.
+func (v *Terminal) setCwd(location string) {
+	dbg.Printf("SetCwd: %s\n", location)
+	u, err := url.Parse(location)
+	if err != nil {
+		dbg.Println("SetCwd: invalid URL:", err)
+		return
+	}
+	switch u.Scheme {
+	case "file", "kitty-shell-cwd":
+	default:
+		dbg.Printf("SetCwd: unsupported scheme %q (ignored)\n", u.Scheme)
+		return
+	}
+	cwd := WorkingDirectory{Host: u.Host, Path: u.Path}
+	if cwd == v.Cwd {
+		return
+	}
+	v.Cwd = cwd
+	if f := v.onCwdChange; f != nil {
+		v.later(func() { f(cwd) })
+	}
+}
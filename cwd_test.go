@@ -0,0 +1,50 @@
+package midterm_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vito/midterm"
+)
+
+func TestWorkingDirectory(t *testing.T) {
+	t.Run("parses and decodes OSC 7", func(t *testing.T) {
+		vt := midterm.NewTerminal(5, 20)
+		var changes []midterm.WorkingDirectory
+		vt.OnCwdChange(func(cwd midterm.WorkingDirectory) {
+			changes = append(changes, cwd)
+		})
+
+		mustFprintf(t, vt, "\x1b]7;file://box/home/me/My%%20Stuff\x07")
+		require.Equal(t, midterm.WorkingDirectory{Host: "box", Path: "/home/me/My Stuff"}, vt.Cwd)
+
+		// reporting the same directory again is not a change
+		mustFprintf(t, vt, "\x1b]7;file://box/home/me/My%%20Stuff\x1b\\")
+		mustFprintf(t, vt, "\x1b]7;file:///tmp;x\x07")
+		require.Equal(t, midterm.WorkingDirectory{Path: "/tmp;x"}, vt.Cwd)
+
+		require.Equal(t, []midterm.WorkingDirectory{
+			{Host: "box", Path: "/home/me/My Stuff"},
+			{Path: "/tmp;x"},
+		}, changes)
+	})
+
+	t.Run("ignores other schemes", func(t *testing.T) {
+		vt := midterm.NewTerminal(5, 20)
+		mustFprintf(t, vt, "\x1b]7;https://example.com/\x07")
+		require.Equal(t, midterm.WorkingDirectory{}, vt.Cwd)
+	})
+
+	t.Run("survives serialization", func(t *testing.T) {
+		vt := midterm.NewTerminal(5, 20)
+		mustFprintf(t, vt, "\x1b]7;file://box/srv/my%%20app\x07$ ")
+
+		data, err := vt.MarshalBinary()
+		require.NoError(t, err)
+
+		clone := midterm.NewTerminal(5, 20)
+		_, err = clone.Write(data)
+		require.NoError(t, err)
+		require.Equal(t, vt.Cwd, clone.Cwd)
+	})
+}
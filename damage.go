@@ -0,0 +1,160 @@
+package midterm
+
+import "slices"
+
+// Damage describes what changed on the screen since damage was last taken, so
+// that a renderer can redraw only those cells.
+type Damage struct {
+	// Full indicates that the whole screen must be redrawn, e.g. because it
+	// was resized, reset or switched. No spans are given.
+	Full bool
+
+	// Spans are the cells whose content or format changed, in order.
+	Spans []DamageSpan
+
+	// Cursor holds the cells which must be redrawn only because the cursor
+	// moved onto or off of them, or was shown, hidden or restyled. Cells
+	// also in Spans are left out.
+	Cursor []DamageSpan
+}
+
+// DamageSpan is a range of cells on a row.
+type DamageSpan struct {
+	Row int
+
+	// Col and End delimit the columns of the span, [Col, End).
+	Col, End int
+}
+
+// damageTracker accumulates damage between calls to TakeDamage. It is only
+// allocated once damage is first taken.
+type damageTracker struct {
+	// full indicates that the whole screen is damaged.
+	full bool
+
+	// rows holds the damaged column ranges of each row, in order and merged.
+	rows [][]DamageSpan
+
+	// cursor and cursorVisible are the cursor state when damage was last
+	// taken.
+	cursor        Cursor
+	cursorVisible bool
+}
+
+// TakeDamage returns the damage accumulated since the last call and starts
+// accumulating again. The first call reports the whole screen as damaged.
+func (v *Terminal) TakeDamage() Damage {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	d := v.Screen.damage
+	if d == nil {
+		d = &damageTracker{full: true}
+		v.Screen.damage = d
+	}
+
+	var damage Damage
+	if d.full {
+		damage.Full = true
+	} else {
+		for _, spans := range d.rows {
+			damage.Spans = append(damage.Spans, spans...)
+		}
+		moved := d.cursor.Y != v.Cursor.Y || d.cursor.X != v.Cursor.X || d.cursor.S != v.Cursor.S
+		if moved && (d.cursorVisible || v.CursorVisible) || d.cursorVisible != v.CursorVisible {
+			for _, c := range []Cursor{d.cursor, v.Cursor} {
+				if !d.covers(c.Y, c.X) {
+					damage.Cursor = appendCursorSpan(damage.Cursor, c, v.Height, v.Width)
+				}
+			}
+		}
+	}
+
+	d.full = false
+	for i := range d.rows {
+		d.rows[i] = d.rows[i][:0]
+	}
+	d.cursor = v.Cursor
+	d.cursorVisible = v.CursorVisible
+	return damage
+}
+
+// appendCursorSpan appends the cell of a cursor to spans, if it is on a screen
+// of the given size and not already included.
+func appendCursorSpan(spans []DamageSpan, c Cursor, height, width int) []DamageSpan {
+	if c.Y < 0 || c.Y >= height || c.X < 0 || c.X >= width {
+		return spans
+	}
+	span := DamageSpan{Row: c.Y, Col: c.X, End: c.X + 1}
+	if slices.Contains(spans, span) {
+		return spans
+	}
+	return append(spans, span)
+}
+
+// covers reports whether a cell is damaged.
+func (d *damageTracker) covers(row, col int) bool {
+	if row < 0 || row >= len(d.rows) {
+		return false
+	}
+	for _, span := range d.rows[row] {
+		if col >= span.Col && col < span.End {
+			return true
+		}
+	}
+	return false
+}
+
+// add records the columns from col up to end of a row as damaged.
+func (d *damageTracker) add(row, col, end int) {
+	if d.full || row < 0 || col >= end {
+		return
+	}
+	for len(d.rows) <= row {
+		d.rows = append(d.rows, nil)
+	}
+	spans := d.rows[row]
+	// the common case is writing just after the last span
+	if n := len(spans); n > 0 && col >= spans[n-1].Col && col <= spans[n-1].End {
+		spans[n-1].End = max(spans[n-1].End, end)
+		return
+	}
+	spans = append(spans, DamageSpan{Row: row, Col: col, End: end})
+	slices.SortFunc(spans, func(a, b DamageSpan) int {
+		return a.Col - b.Col
+	})
+	// merge overlapping and adjacent spans
+	merged := spans[:1]
+	for _, span := range spans[1:] {
+		last := &merged[len(merged)-1]
+		if span.Col <= last.End {
+			last.End = max(last.End, span.End)
+		} else {
+			merged = append(merged, span)
+		}
+	}
+	d.rows[row] = merged
+}
+
+// damaged records the columns from col up to end of a row as damaged.
+func (v *Screen) damaged(y, col, end int) {
+	if v.damage != nil {
+		v.damage.add(y, col, end)
+	}
+}
+
+// damagedRows records the rows from start through end as damaged.
+func (v *Screen) damagedRows(start, end int) {
+	if v.damage == nil {
+		return
+	}
+	for y := max(start, 0); y <= end && y < v.Height; y++ {
+		v.damage.add(y, 0, v.Width)
+	}
+}
+
+// damagedAll records the whole screen as damaged.
+func (v *Screen) damagedAll() {
+	if v.damage != nil {
+		v.damage.full = true
+	}
+}
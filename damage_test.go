@@ -0,0 +1,147 @@
+package midterm_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vito/midterm"
+)
+
+func TestDamage(t *testing.T) {
+	t.Run("starts fully damaged", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 10)
+		require.Equal(t, midterm.Damage{Full: true}, vt.TakeDamage())
+		require.Equal(t, midterm.Damage{}, vt.TakeDamage())
+	})
+
+	t.Run("accumulates written spans", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 10)
+		vt.TakeDamage()
+
+		mustFprintf(t, vt, "abc\x1b[3;5Hxy\x1b[1;3Hz")
+		require.Equal(t, []midterm.DamageSpan{
+			{Row: 0, Col: 0, End: 3},
+			{Row: 2, Col: 4, End: 6},
+		}, vt.TakeDamage().Spans)
+
+		mustFprintf(t, vt, "\x1b[1;8Hq\x1b[1;2Hr")
+		require.Equal(t, []midterm.DamageSpan{
+			{Row: 0, Col: 1, End: 2},
+			{Row: 0, Col: 7, End: 8},
+		}, vt.TakeDamage().Spans)
+	})
+
+	t.Run("loses nothing written while damage is taken", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 100)
+		vt.TakeDamage()
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for col := 1; col <= 100; col++ {
+				fmt.Fprintf(vt, "\x1b[1;%dHx", col)
+			}
+		}()
+		damaged := map[int]bool{}
+		take := func() {
+			for _, span := range vt.TakeDamage().Spans {
+				for col := span.Col; col < span.End; col++ {
+					damaged[col] = true
+				}
+			}
+		}
+		for running := true; running; {
+			select {
+			case <-done:
+				running = false
+			default:
+			}
+			take()
+		}
+		require.Len(t, damaged, 100)
+	})
+
+	t.Run("loses nothing written byte by byte while damage is taken", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 100)
+		vt.TakeDamage()
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for range 100 {
+				_ = vt.WriteByte('x')
+			}
+		}()
+		damaged := map[int]bool{}
+		for running := true; running; {
+			select {
+			case <-done:
+				running = false
+			default:
+			}
+			for _, span := range vt.TakeDamage().Spans {
+				for col := span.Col; col < span.End; col++ {
+					damaged[col] = true
+				}
+			}
+		}
+		require.Len(t, damaged, 100)
+	})
+
+	t.Run("damages whole rows when they scroll", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 10)
+		vt.TakeDamage()
+
+		mustFprintf(t, vt, "\x1b[3;1H\n")
+		require.Equal(t, []midterm.DamageSpan{
+			{Row: 0, Col: 0, End: 10},
+			{Row: 1, Col: 0, End: 10},
+			{Row: 2, Col: 0, End: 10},
+		}, vt.TakeDamage().Spans)
+	})
+
+	t.Run("reports cursor movement separately", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 10)
+		mustFprintf(t, vt, "\x1b[?25h")
+		vt.TakeDamage()
+
+		mustFprintf(t, vt, "\x1b[2;4H")
+		damage := vt.TakeDamage()
+		require.Empty(t, damage.Spans)
+		require.Equal(t, []midterm.DamageSpan{
+			{Row: 0, Col: 0, End: 1},
+			{Row: 1, Col: 3, End: 4},
+		}, damage.Cursor)
+
+		// the cell written is already damaged
+		mustFprintf(t, vt, "a")
+		damage = vt.TakeDamage()
+		require.Equal(t, []midterm.DamageSpan{{Row: 1, Col: 3, End: 4}}, damage.Spans)
+		require.Equal(t, []midterm.DamageSpan{{Row: 1, Col: 4, End: 5}}, damage.Cursor)
+
+		mustFprintf(t, vt, "\x1b[?25l")
+		require.Equal(t, []midterm.DamageSpan{{Row: 1, Col: 4, End: 5}}, vt.TakeDamage().Cursor)
+
+		// a hidden cursor doesn't need redrawing
+		mustFprintf(t, vt, "\x1b[3;1H")
+		require.Equal(t, midterm.Damage{}, vt.TakeDamage())
+	})
+
+	t.Run("is full after resizing or switching screens", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 10)
+		vt.TakeDamage()
+
+		vt.Resize(4, 10)
+		require.True(t, vt.TakeDamage().Full)
+
+		mustFprintf(t, vt, "\x1b[?1049h")
+		require.True(t, vt.TakeDamage().Full)
+
+		mustFprintf(t, vt, "x")
+		require.Equal(t, []midterm.DamageSpan{{Row: 0, Col: 0, End: 1}}, vt.TakeDamage().Spans)
+
+		mustFprintf(t, vt, "\x1b[?1049l")
+		require.True(t, vt.TakeDamage().Full)
+	})
+}
@@ -0,0 +1,194 @@
+package midterm
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/danielgatis/go-ansicode"
+	"github.com/danielgatis/go-vte"
+)
+
+// decoder parses terminal input and dispatches it to a Terminal.
+//
+// It is a thin layer over ansicode's decoder which additionally intercepts
+// sequences that ansicode drops on the floor, like DCS strings carrying sixel
+// graphics and APC strings carrying kitty graphics.
+type decoder struct {
+	parser    *vte.Parser
+	performer *performer
+
+	// The parser ignores APC strings entirely, so they're picked out of the
+	// input before it gets there.
+	apcState apcState
+	apc      []byte
+}
+
+type apcState int
+
+const (
+	apcGround apcState = iota
+	// apcEscape means an ESC was held back in case it begins an APC.
+	apcEscape
+	apcString
+	// apcStringEscape means an ESC was seen within an APC, which should be
+	// followed by \ to terminate it.
+	apcStringEscape
+)
+
+// maxAPCSize bounds the size of an APC string. Kitty graphics data is sent in
+// chunks of at most 4096 bytes, so anything much larger is bogus.
+const maxAPCSize = 1 << 20
+
+func newDecoder(vt *Terminal) *decoder {
+	p := &performer{
+		Performer: ansicode.NewPerformer(vt),
+		vt:        vt,
+	}
+	return &decoder{
+		parser:    vte.NewParser(p),
+		performer: p,
+	}
+}
+
+// WriteByte writes a byte to the decoder.
+func (d *decoder) WriteByte(c byte) error {
+	d.advance(c)
+	return nil
+}
+
+// Write writes a byte slice to the decoder.
+func (d *decoder) Write(p []byte) (int, error) {
+	for _, c := range p {
+		d.advance(c)
+	}
+	return len(p), nil
+}
+
+func (d *decoder) advance(c byte) {
+	switch d.apcState {
+	case apcGround:
+		if c == 0x1b {
+			d.apcState = apcEscape
+			return
+		}
+		d.parser.Advance(c)
+	case apcEscape:
+		if c == '_' {
+			d.apcState = apcString
+			d.apc = d.apc[:0]
+			return
+		}
+		d.apcState = apcGround
+		d.parser.Advance(0x1b)
+		d.advance(c)
+	case apcString:
+		if c == 0x1b {
+			d.apcState = apcStringEscape
+			return
+		}
+		if len(d.apc) < maxAPCSize {
+			d.apc = append(d.apc, c)
+		}
+	case apcStringEscape:
+		if c == '\\' {
+			d.apcState = apcGround
+			d.performer.ApcDispatch(d.apc)
+			return
+		}
+		// an unterminated APC is discarded, and the ESC begins a new sequence
+		d.apcState = apcEscape
+		d.advance(c)
+	}
+}
+
+// performer handles parsed sequences, deferring to ansicode for everything
+// it understands.
+type performer struct {
+	*ansicode.Performer
+
+	vt *Terminal
+
+	// sixel is the sixel image being received, if any.
+	sixel *sixelDecoder
+}
+
+// Hook is called at the start of a DCS string.
+func (p *performer) Hook(params [][]uint16, intermediates []byte, ignore bool, r rune) {
+	if r == 'q' && len(intermediates) == 0 && !ignore {
+		dbg.Printf("Sixel: params=%v\n", params)
+		p.sixel = newSixelDecoder(params)
+		return
+	}
+	p.Performer.Hook(params, intermediates, ignore, r)
+}
+
+// Put is called for each byte of a DCS string.
+func (p *performer) Put(b byte) {
+	if p.sixel != nil {
+		p.sixel.put(b)
+		return
+	}
+	p.Performer.Put(b)
+}
+
+// OscDispatch is called at the end of an OSC string.
+func (p *performer) OscDispatch(params [][]byte, bellTerminated bool) {
+	if len(params) == 0 {
+		return
+	}
+	switch string(params[0]) {
+	case "7":
+		if len(params) < 2 {
+			return
+		}
+		// the URL may itself contain semicolons
+		p.vt.setCwd(string(bytes.Join(params[1:], []byte{';'})))
+	case "9":
+		p.vt.osc9(oscStrings(params[1:]))
+	case "133":
+		p.vt.shellMark(oscStrings(params[1:]))
+	case "777":
+		p.vt.osc777(oscStrings(params[1:]))
+	case "1337":
+		// the payload itself contains semicolons
+		payload := string(bytes.Join(params[1:], []byte{';'}))
+		if strings.HasPrefix(payload, "File=") {
+			p.vt.itermFile(payload)
+			return
+		}
+		dbg.Printf("OSC 1337: %q (ignored)\n", payload)
+	default:
+		p.Performer.OscDispatch(params, bellTerminated)
+	}
+}
+
+// oscStrings converts OSC parameters to strings.
+func oscStrings(params [][]byte) []string {
+	strs := make([]string, len(params))
+	for i, param := range params {
+		strs[i] = string(param)
+	}
+	return strs
+}
+
+// ApcDispatch is called with the contents of an APC string.
+func (p *performer) ApcDispatch(data []byte) {
+	if len(data) > 0 && data[0] == 'G' {
+		p.vt.kittyGraphics(data[1:])
+		return
+	}
+	dbg.Printf("APC: %q (ignored)\n", data)
+}
+
+// Unhook is called at the end of a DCS string.
+func (p *performer) Unhook() {
+	if p.sixel != nil {
+		img := p.sixel.image()
+		p.sixel = nil
+		if img != nil {
+			p.vt.placeSixel(img)
+		}
+		return
+	}
+	p.Performer.Unhook()
+}
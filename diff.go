@@ -0,0 +1,207 @@
+package midterm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/muesli/termenv"
+)
+
+// DiffRenderer renders the terminal onto an outer terminal, remembering the
+// frame it last wrote so that each render writes only the cursor movements,
+// formats and characters needed to bring the outer terminal up to date.
+//
+// It assumes that nothing else writes to the outer terminal in between
+// renders. Call Invalidate if something does, e.g. after it is resized.
+type DiffRenderer struct {
+	vt *Terminal
+
+	// Fg and Bg are the colors to use for cells without one, as with
+	// RenderFgBg.
+	Fg, Bg termenv.Color
+
+	// frame is the last frame written, or nil if the outer terminal's
+	// contents are unknown.
+	frame []Line
+
+	// cursorY, cursorX and cursorVisible are the state of the outer
+	// terminal's cursor after the last frame.
+	cursorY, cursorX int
+	cursorVisible    bool
+}
+
+// NewDiffRenderer returns a DiffRenderer which will repaint the whole outer
+// terminal on its first render.
+func (v *Terminal) NewDiffRenderer() *DiffRenderer {
+	return &DiffRenderer{vt: v}
+}
+
+// Invalidate forgets the last frame written, so that the next render repaints
+// the whole outer terminal.
+func (r *DiffRenderer) Invalidate() {
+	r.frame = nil
+}
+
+// diffSkipMax is the most unchanged cells to rewrite rather than moving the
+// cursor over them, which takes a few bytes itself.
+const diffSkipMax = 4
+
+// Render writes the changes since the last frame to w, in a single write.
+func (r *DiffRenderer) Render(w io.Writer) error {
+	r.vt.mut.Lock()
+	frame := r.vt.frame()
+	cursor, cursorVisible := r.vt.Cursor, r.vt.CursorVisible
+	r.vt.mut.Unlock()
+
+	var buf bytes.Buffer
+	prev := r.frame
+	if len(prev) != len(frame) || len(prev) > 0 && len(prev[0].Content) != len(frame[0].Content) {
+		// start from a blank screen; cells left blank needn't be written
+		buf.WriteString(EmptyFormat.RenderFgBg(r.Fg, r.Bg) + "\x1b[H\x1b[2J")
+		prev = make([]Line, len(frame))
+		for row, line := range frame {
+			prev[row] = blankLine(len(line.Content))
+		}
+		r.cursorY, r.cursorX = 0, 0
+		// the visibility is unknown, so always set it
+		r.cursorVisible = !cursorVisible
+	}
+
+	if r.cursorVisible && !cursorVisible {
+		// hide it first, so it doesn't flicker around while drawing
+		buf.WriteString("\x1b[?25l")
+	}
+
+	pen := EmptyFormat
+	setFormat := func(f Format) {
+		if f == pen {
+			return
+		}
+		if leaksInto(pen, f, r.Fg, r.Bg) {
+			buf.WriteString(resetSeq)
+		}
+		buf.WriteString(f.RenderFgBg(r.Fg, r.Bg))
+		pen = f
+	}
+	// x is the column of the outer terminal's cursor, or -1 if it isn't
+	// known, e.g. after writing to the last column
+	y, x := r.cursorY, r.cursorX
+	moveTo := func(row, col int) {
+		if row == y && col == x {
+			return
+		}
+		fmt.Fprintf(&buf, "\x1b[%d;%dH", row+1, col+1)
+		y, x = row, col
+	}
+	put := func(line Line, col int) {
+		setFormat(line.Format[col])
+		c := line.Content[col]
+		if c == 0 {
+			c = ' '
+		}
+		buf.WriteRune(c)
+		x++
+		if x >= len(line.Content) {
+			x = -1
+		}
+	}
+
+	for row, line := range frame {
+		old := prev[row]
+		for col := 0; col < len(line.Content); col++ {
+			if sameCell(old, line, col) {
+				continue
+			}
+			if row == y && x >= 0 && col > x && col-x <= diffSkipMax {
+				// rewriting the unchanged cells in between is shorter than
+				// moving over them, unless it means changing formats
+				skip := true
+				for c := x; c < col; c++ {
+					if line.Format[c] != pen {
+						skip = false
+						break
+					}
+				}
+				if skip {
+					for x < col {
+						put(line, x)
+					}
+				}
+			}
+			moveTo(row, col)
+			put(line, col)
+		}
+	}
+
+	setFormat(EmptyFormat)
+	if cursorVisible {
+		moveTo(cursor.Y, cursor.X)
+		if !r.cursorVisible {
+			buf.WriteString("\x1b[?25h")
+		}
+	}
+
+	r.frame = frame
+	r.cursorY, r.cursorX = y, x
+	r.cursorVisible = cursorVisible
+	if buf.Len() == 0 {
+		return nil
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// frame returns the rows of the screen as they are rendered, with highlights
+// applied, but without the cursor.
+func (vt *Terminal) frame() []Line {
+	flash := vt.flashing()
+	frame := make([]Line, vt.Height)
+	for row := range frame {
+		line := vt.line(row)
+		if len(line.Content) > vt.Width {
+			line.Content, line.Format = line.Content[:vt.Width], line.Format[:vt.Width]
+		}
+		for len(line.Content) < vt.Width {
+			line.Content = append(line.Content, ' ')
+			line.Format = append(line.Format, EmptyFormat)
+		}
+		override := vt.rowOverrides(row)
+		for col, f := range line.Format {
+			if override != nil {
+				if hlF, ok := override(col); ok {
+					f = hlF
+				}
+			}
+			if flash {
+				f.SetReverse(!f.IsReverse())
+			}
+			// resets are written as needed when the format changes
+			f.SetReset(false)
+			line.Format[col] = f
+		}
+		frame[row] = line
+	}
+	return frame
+}
+
+// blankLine returns a line of width blank cells.
+func blankLine(width int) Line {
+	return Line{
+		Content: slices.Repeat([]rune{' '}, width),
+		Format:  make([]Format, width),
+	}
+}
+
+// sameCell reports whether a cell looks the same on two lines.
+func sameCell(a, b Line, col int) bool {
+	ar, br := a.Content[col], b.Content[col]
+	if ar == 0 {
+		ar = ' '
+	}
+	if br == 0 {
+		br = ' '
+	}
+	return ar == br && a.Format[col] == b.Format[col]
+}
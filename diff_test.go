@@ -0,0 +1,121 @@
+package midterm_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vito/midterm"
+)
+
+func TestDiffRenderer(t *testing.T) {
+	t.Run("repaints the whole screen at first", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 10)
+		mustFprintf(t, vt, "hello\r\n\x1b[31mworld")
+		r := vt.NewDiffRenderer()
+
+		var buf bytes.Buffer
+		require.NoError(t, r.Render(&buf))
+		require.Contains(t, buf.String(), "\x1b[2J")
+		requireMirrored(t, vt, buf.Bytes())
+	})
+
+	t.Run("writes nothing when nothing changed", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 10)
+		mustFprintf(t, vt, "hello")
+		r := vt.NewDiffRenderer()
+		require.NoError(t, r.Render(&bytes.Buffer{}))
+
+		var buf bytes.Buffer
+		require.NoError(t, r.Render(&buf))
+		require.Empty(t, buf.String())
+	})
+
+	t.Run("writes only the changed cells", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 10)
+		mustFprintf(t, vt, "hello\r\nworld")
+		r := vt.NewDiffRenderer()
+		var all bytes.Buffer
+		require.NoError(t, r.Render(&all))
+
+		mustFprintf(t, vt, "\x1b[1;2Ha\x1b[2;4H\x1b[1mLD")
+		var buf bytes.Buffer
+		require.NoError(t, r.Render(&buf))
+		require.Equal(t, "\x1b[1;2Ha\x1b[2;4H\x1b[1mLD\x1b[0m", buf.String())
+
+		all.Write(buf.Bytes())
+		requireMirrored(t, vt, all.Bytes())
+	})
+
+	t.Run("rewrites short gaps instead of moving", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 10)
+		mustFprintf(t, vt, "abcdef")
+		r := vt.NewDiffRenderer()
+		require.NoError(t, r.Render(&bytes.Buffer{}))
+
+		mustFprintf(t, vt, "\x1b[1;1HA\x1b[1;4HD")
+		var buf bytes.Buffer
+		require.NoError(t, r.Render(&buf))
+		require.Equal(t, "\x1b[1;1HAbcD", buf.String())
+	})
+
+	t.Run("shows and moves the cursor", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 10)
+		r := vt.NewDiffRenderer()
+		require.NoError(t, r.Render(&bytes.Buffer{}))
+
+		mustFprintf(t, vt, "\x1b[?25h\x1b[2;3H")
+		var buf bytes.Buffer
+		require.NoError(t, r.Render(&buf))
+		require.Equal(t, "\x1b[2;3H\x1b[?25h", buf.String())
+
+		mustFprintf(t, vt, "\x1b[?25l")
+		buf.Reset()
+		require.NoError(t, r.Render(&buf))
+		require.Equal(t, "\x1b[?25l", buf.String())
+	})
+
+	t.Run("follows scrolling and resizing", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 10)
+		r := vt.NewDiffRenderer()
+		var all bytes.Buffer
+		for i := range 5 {
+			mustFprintf(t, vt, "line %d\r\n", i)
+			require.NoError(t, r.Render(&all))
+		}
+		requireMirrored(t, vt, all.Bytes())
+
+		vt.Resize(4, 12)
+		var buf bytes.Buffer
+		require.NoError(t, r.Render(&buf))
+		require.Contains(t, buf.String(), "\x1b[2J")
+		requireMirrored(t, vt, buf.Bytes())
+	})
+}
+
+// requireMirrored requires that writing out to a terminal of the same size
+// reproduces the screen of vt.
+func requireMirrored(t *testing.T, vt *midterm.Terminal, out []byte) {
+	t.Helper()
+	outer := midterm.NewTerminal(vt.Height, vt.Width)
+	_, err := outer.Write(out)
+	require.NoError(t, err)
+	for row := range vt.Height {
+		require.Equal(t, strings.TrimRight(string(vt.Content[row]), " "), strings.TrimRight(string(outer.Content[row]), " "), "row %d", row)
+		require.Equal(t, cellFormats(vt, row), cellFormats(outer, row), "row %d", row)
+	}
+}
+
+// cellFormats returns the format of each cell of a row, ignoring resets.
+func cellFormats(vt *midterm.Terminal, row int) []midterm.Format {
+	var formats []midterm.Format
+	for region := range vt.Format.Regions(row) {
+		f := region.F
+		f.SetReset(false)
+		for range region.Size {
+			formats = append(formats, f)
+		}
+	}
+	return formats
+}
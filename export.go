@@ -0,0 +1,176 @@
+package midterm
+
+import (
+	"bytes"
+	"html"
+	"math"
+	"strings"
+)
+
+// Range is a range of cells of the screen and its scrollback, from Start
+// through End inclusive. Use Position to find the position of a cell.
+type Range struct {
+	Start, End Position
+
+	// Block selects the rectangle with Start and End at its corners, rather
+	// than every cell between them in reading order.
+	Block bool
+}
+
+// normalize orders the ends of the range.
+func (r Range) normalize() Range {
+	if r.Block {
+		r.Start.Line, r.End.Line = min(r.Start.Line, r.End.Line), max(r.Start.Line, r.End.Line)
+		r.Start.Col, r.End.Col = min(r.Start.Col, r.End.Col), max(r.Start.Col, r.End.Col)
+	} else if r.End.Line < r.Start.Line || r.End.Line == r.Start.Line && r.End.Col < r.Start.Col {
+		r.Start, r.End = r.End, r.Start
+	}
+	return r
+}
+
+// cols returns the range of columns covered on a line of a normalized range.
+func (r Range) cols(line int) (int, int, bool) {
+	if line < r.Start.Line || line > r.End.Line {
+		return 0, 0, false
+	}
+	if r.Block {
+		return r.Start.Col, r.End.Col + 1, true
+	}
+	from, to := 0, math.MaxInt
+	if line == r.Start.Line {
+		from = r.Start.Col
+	}
+	if line == r.End.Line && r.End.Col < math.MaxInt {
+		to = r.End.Col + 1
+	}
+	return from, to, true
+}
+
+// Text returns the text in r. Trailing whitespace is removed from each line,
+// and lines soft-wrapped across rows are joined, except for blocks where each
+// row is a line of its own. Lines which are no longer available, e.g. because
+// they were scrolled off without being kept in the Scrollback, are omitted.
+func (v *Terminal) Text(r Range) string {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	return v.rangeText(v.Screen, r)
+}
+
+// ANSI returns the text in r like Text, with its formatting as escape
+// sequences. Each line ends with a reset.
+func (v *Terminal) ANSI(r Range) string {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	var buf bytes.Buffer
+	v.exportRange(v.Screen, r, func(line Line) {
+		_ = renderCells(&buf, line, 0, nil, nil)
+	}, func() {
+		buf.WriteByte('\n')
+	})
+	return buf.String()
+}
+
+// HTMLRange returns the text in r like Text, as an HTML fragment styled like
+// the one returned by HTML.
+func (v *Terminal) HTMLRange(r Range) string {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	var buf bytes.Buffer
+	buf.WriteString(`<pre style="color:white;background-color:black;">`)
+	v.exportRange(v.Screen, r, func(line Line) {
+		for start := 0; start < len(line.Content); {
+			end := start + 1
+			for end < len(line.Content) && line.Format[end] == line.Format[start] {
+				end++
+			}
+			writeHTMLSpan(&buf, line.Format[start], line.Content[start:end])
+			start = end
+		}
+	}, func() {
+		buf.WriteByte('\n')
+	})
+	buf.WriteString("</pre>")
+	return buf.String()
+}
+
+// rangeText returns the text in r of the screen s.
+func (v *Terminal) rangeText(s *Screen, r Range) string {
+	var buf strings.Builder
+	v.exportRange(s, r, func(line Line) {
+		buf.WriteString(strings.TrimRight(string(line.Content), " "))
+	}, func() {
+		buf.WriteByte('\n')
+	})
+	return buf.String()
+}
+
+// exportRange calls write with the cells in r of each line of the screen s,
+// joining the rows of soft-wrapped lines and trimming trailing blanks.
+func (v *Terminal) exportRange(s *Screen, r Range, write func(line Line), newline func()) {
+	r = r.normalize()
+	var cur Line
+	var pending, written bool
+	flush := func() {
+		if !pending {
+			return
+		}
+		if written {
+			newline()
+		}
+		write(trimBlanks(cur))
+		cur, pending, written = Line{}, false, true
+	}
+	for line := r.Start.Line; line <= r.End.Line; line++ {
+		l, ok := v.historyLine(s, line)
+		if !ok {
+			continue
+		}
+		from, to, _ := r.cols(line)
+		from, to = min(from, len(l.Content)), min(to, len(l.Content))
+		to = max(from, to)
+		cur.Content = append(cur.Content, l.Content[from:to]...)
+		for col := from; col < to; col++ {
+			f := EmptyFormat
+			if col < len(l.Format) && l.Format[col] != Reset {
+				f = l.Format[col]
+			}
+			cur.Format = append(cur.Format, f)
+		}
+		pending = true
+		if r.Block || !l.Info.Wrapped || line == r.End.Line {
+			flush()
+		}
+	}
+	flush()
+}
+
+// historyLine is like lineAt, returning the formats of the line as well.
+func (v *Terminal) historyLine(s *Screen, line int) (Line, bool) {
+	row := line - v.scrolled()
+	switch {
+	case row >= 0 && row < len(s.Content):
+		return s.line(row), true
+	case row < 0 && s == v.mainScreen() && v.Scrollback != nil && v.Scrollback.Len()+row >= 0:
+		return v.Scrollback.Line(v.Scrollback.Len() + row), true
+	default:
+		return Line{}, false
+	}
+}
+
+// trimBlanks removes trailing blank cells with no formatting from a line.
+func trimBlanks(line Line) Line {
+	n := len(line.Content)
+	for n > 0 && line.Content[n-1] == ' ' &&
+		(line.Format[n-1] == EmptyFormat || line.Format[n-1] == Reset) {
+		n--
+	}
+	line.Content, line.Format = line.Content[:n], line.Format[:n]
+	return line
+}
+
+// writeHTMLSpan writes text styled with f as an HTML span.
+func writeHTMLSpan(buf *bytes.Buffer, f Format, text []rune) {
+	buf.WriteString(`<span style="` + f.css() + `">`)
+	buf.WriteString(html.EscapeString(string(text)))
+	buf.WriteString("</span>")
+}
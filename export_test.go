@@ -0,0 +1,7 @@
+package midterm
+
+// ShareDecoders points dst at the input decoders of src, so that terminals can
+// be compared regardless of the parser state left over from their input.
+func ShareDecoders(dst, src *Terminal) {
+	dst.Decoder, dst.decoder = src.Decoder, src.decoder
+}
@@ -18,7 +18,12 @@ func (v *Terminal) Backspace() {
 
 // Bell rings the bell.
 func (v *Terminal) Bell() {
-	// no-op
+	dbg.Println("Bell")
+	v.Bells++
+	v.LastBell = time.Now()
+	if v.onBell != nil {
+		v.later(v.onBell)
+	}
 }
 
 // CarriageReturn moves the cursor to the beginning of the line.
@@ -65,8 +70,12 @@ func (v *Terminal) ClearScreen(mode ansicode.ClearMode) {
 		for row := 0; row < h; row++ {
 			v.clearRow(row, f)
 		}
+		// clearing the screen takes kitty images with it, too
+		v.deletePlacements(func(*ImagePlacement) bool { return true })
 	case ansicode.ClearModeSaved:
-		dbg.Println("TODO: ClearModeSaved")
+		if v.Scrollback != nil && !v.IsAlt {
+			v.Scrollback.Clear()
+		}
 	}
 }
 
@@ -139,9 +148,9 @@ func (v *Terminal) DeviceStatus(n int) {
 	}
 	switch n {
 	case 5:
-		_, _ = fmt.Fprint(v.ForwardResponses, termenv.CSI+"0n")
+		v.respond(termenv.CSI + "0n")
 	case 6:
-		_, _ = fmt.Fprintf(v.ForwardResponses, "%s%d;%dR", termenv.CSI, v.Cursor.Y+1, v.Cursor.X+1)
+		v.respond("%s%d;%dR", termenv.CSI, v.Cursor.Y+1, v.Cursor.X+1)
 	default:
 		dbg.Println("UNKNOWN DEVICE STATUS QUERY", n)
 	}
@@ -194,7 +203,7 @@ func (v *Terminal) IdentifyTerminal(b byte) {
 		return
 	}
 	dbg.Println("IdentifyTerminal: RESPONDING VT102")
-	_, _ = fmt.Fprint(v.ForwardResponses, termenv.CSI+"?62;22c") // VT220 + ANSI
+	v.respond(termenv.CSI + "?62;22c") // VT220 + ANSI
 }
 
 // Input inputs a rune to be displayed.
@@ -300,7 +309,7 @@ func (v *Terminal) ReportKeyboardMode() {
 		return
 	}
 	dbg.Println("ReportKeyboardMode (forwarding)")
-	_, _ = fmt.Fprint(v.ForwardResponses, termenv.CSI+"?0u")
+	v.respond(termenv.CSI + "?0u")
 }
 
 // ReportModifyOtherKeys reports the modify other keys mode. (XTERM)
@@ -387,6 +396,7 @@ func (v *Terminal) SetKeypadApplicationMode() {
 // SetMode sets the given mode.
 func (v *Terminal) SetMode(mode ansicode.TerminalMode) {
 	dbg.Println("SetMode", mode)
+	v.Modes.setMode(mode, true)
 	var forward bool
 	switch mode {
 	case ansicode.TerminalModeCursorKeys:
@@ -625,6 +635,7 @@ func (v *Terminal) UnsetKeypadApplicationMode() {
 // UnsetMode unsets the given mode.
 func (v *Terminal) UnsetMode(mode ansicode.TerminalMode) {
 	dbg.Println("UnsetMode", mode)
+	v.Modes.setMode(mode, false)
 	var forward bool
 	switch mode {
 	case ansicode.TerminalModeCursorKeys:
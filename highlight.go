@@ -0,0 +1,200 @@
+package midterm
+
+import (
+	"regexp"
+	"slices"
+)
+
+// HighlightRule restyles text matching a pattern whenever the terminal is
+// rendered, e.g. to color errors in logs. Rules are matched against each row
+// separately.
+type HighlightRule struct {
+	// Pattern matches the text to restyle.
+	Pattern *regexp.Regexp
+
+	// Style is the Format override for the matched cells.
+	Style Format
+
+	// Line restyles the whole row of a match, rather than only the matched
+	// text.
+	Line bool
+
+	// Priority decides which rule applies where matches overlap. The highest
+	// priority wins, and later rules win ties.
+	Priority int
+}
+
+// highlightRules holds the highlight rules along with the spans they matched
+// on each row of the screen.
+type highlightRules struct {
+	rules []HighlightRule
+
+	// screen is the screen the rows were matched on.
+	screen *Screen
+
+	// rows are the spans matched on each row.
+	rows []ruleRow
+}
+
+// ruleRow caches the spans matched on a row, as of a value of its Changes
+// counter.
+type ruleRow struct {
+	valid   bool
+	changes uint64
+	spans   []ruleSpan
+}
+
+// ruleSpan is a range of columns restyled by a rule.
+type ruleSpan struct {
+	col, end int
+	style    Format
+}
+
+// SetHighlightRules replaces the highlight rules. Rows are only matched
+// against them as they change.
+func (v *Terminal) SetHighlightRules(rules ...HighlightRule) {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	if len(rules) == 0 {
+		v.rules = nil
+		return
+	}
+	v.rules = &highlightRules{rules: slices.Clone(rules)}
+}
+
+// HighlightRules returns the highlight rules.
+func (v *Terminal) HighlightRules() []HighlightRule {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	if v.rules == nil {
+		return nil
+	}
+	return slices.Clone(v.rules.rules)
+}
+
+// ruleSpans returns the spans of a row of the screen restyled by highlight
+// rules, matching them again only if the row has changed.
+func (v *Terminal) ruleSpans(row int) []ruleSpan {
+	h := v.rules
+	if h == nil || row < 0 || row >= len(v.Content) {
+		return nil
+	}
+	if h.screen != v.Screen || len(h.rows) != len(v.Content) {
+		h.screen = v.Screen
+		h.rows = make([]ruleRow, len(v.Content))
+	}
+	r := &h.rows[row]
+	if !r.valid || r.changes != v.Changes[row] {
+		*r = ruleRow{
+			valid:   true,
+			changes: v.Changes[row],
+			spans:   h.match(v.Content[row]),
+		}
+	}
+	return r.spans
+}
+
+// match evaluates the rules against a row, returning the spans to restyle in
+// order.
+func (h *highlightRules) match(content []rune) []ruleSpan {
+	line := string(content)
+	// cols maps byte offsets to columns
+	cols := make([]int, len(line)+1)
+	var col int
+	for i := range line {
+		cols[i] = col
+		col++
+	}
+	cols[len(line)] = col
+
+	// winners holds the index of the rule applying to each column, if any
+	var winners []int
+	for i, rule := range h.rules {
+		if rule.Pattern == nil {
+			continue
+		}
+		for _, loc := range rule.Pattern.FindAllStringIndex(line, -1) {
+			if loc[0] == loc[1] {
+				continue
+			}
+			from, to := cols[loc[0]], cols[loc[1]]
+			if rule.Line {
+				from, to = 0, len(content)
+			}
+			if winners == nil {
+				winners = make([]int, len(content))
+				for col := range winners {
+					winners[col] = -1
+				}
+			}
+			for col := from; col < to; col++ {
+				if w := winners[col]; w < 0 || rule.Priority >= h.rules[w].Priority {
+					winners[col] = i
+				}
+			}
+		}
+	}
+
+	var spans []ruleSpan
+	for col := 0; col < len(winners); {
+		w := winners[col]
+		end := col + 1
+		for end < len(winners) && winners[end] == w {
+			end++
+		}
+		if w >= 0 {
+			spans = append(spans, ruleSpan{col: col, end: end, style: h.rules[w].Style})
+		}
+		col = end
+	}
+	return spans
+}
+
+// shiftRules follows the rows from start through end moving by delta rows, so
+// that the spans matched on them don't need to be matched again.
+func (v *Terminal) shiftRules(start, end, delta int) {
+	h := v.rules
+	if h == nil || h.screen != v.Screen || len(h.rows) == 0 {
+		return
+	}
+	end = min(end, len(h.rows)-1)
+	rows := slices.Clone(h.rows)
+	for row := start; row <= end; row++ {
+		if from := row - delta; from >= start && from <= end {
+			h.rows[row] = rows[from]
+		} else {
+			h.rows[row] = ruleRow{}
+		}
+	}
+}
+
+// ruleHighlightLine overrides the formats of the cells of a line restyled by
+// highlight rules, for lines which are not on the screen.
+func (v *Terminal) ruleHighlightLine(l Line) Line {
+	if v.rules == nil {
+		return l
+	}
+	spans := v.rules.match(l.Content)
+	if len(spans) == 0 {
+		return l
+	}
+	format := make([]Format, len(l.Content))
+	copy(format, l.Format)
+	for _, span := range spans {
+		for col := span.col; col < span.end; col++ {
+			format[col] = span.style
+		}
+	}
+	l.Format = format
+	return l
+}
+
+// ruleStyleAt returns the style of the span covering col, if any.
+func ruleStyleAt(spans []ruleSpan, col int) (Format, bool) {
+	for _, span := range spans {
+		if col >= span.col && col < span.end {
+			return span.style, true
+		}
+	}
+	return Format{}, false
+}
@@ -0,0 +1,96 @@
+package midterm_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/muesli/termenv"
+	"github.com/stretchr/testify/require"
+	"github.com/vito/midterm"
+)
+
+func TestHighlightRules(t *testing.T) {
+	errorStyle := midterm.Format{Fg: termenv.ANSIRed, Properties: midterm.ResetBit}
+	warnStyle := midterm.Format{Fg: termenv.ANSIYellow, Properties: midterm.ResetBit}
+	idStyle := midterm.Format{Fg: termenv.ANSICyan, Properties: midterm.ResetBit | midterm.UnderlineBit}
+	rules := []midterm.HighlightRule{
+		{Pattern: regexp.MustCompile(`\bERROR\b`), Style: errorStyle, Line: true},
+		{Pattern: regexp.MustCompile(`\bWARN\b`), Style: warnStyle, Line: true},
+		{Pattern: regexp.MustCompile(`req-[0-9a-f]+`), Style: idStyle, Priority: 1},
+	}
+
+	render := func(t *testing.T, vt *midterm.Terminal, row int) string {
+		var buf strings.Builder
+		require.NoError(t, vt.RenderLine(&buf, row))
+		return buf.String()
+	}
+
+	t.Run("restyles matching text and lines", func(t *testing.T) {
+		vt := midterm.NewTerminal(4, 24)
+		vt.SetHighlightRules(rules...)
+		require.Equal(t, rules, vt.HighlightRules())
+		mustFprintf(t, vt, "INFO req-1a ok\r\nERROR req-2b failed\r\nWARN slow")
+
+		require.Equal(t,
+			"INFO "+idStyle.Render()+"req-1a"+midterm.EmptyFormat.Render()+" ok          \x1b[0m",
+			render(t, vt, 0))
+		require.Equal(t,
+			errorStyle.Render()+"ERROR "+idStyle.Render()+"req-2b"+errorStyle.Render()+" failed     \x1b[0m",
+			render(t, vt, 1))
+		require.Equal(t,
+			warnStyle.Render()+"WARN slow               \x1b[0m",
+			render(t, vt, 2))
+
+		require.Contains(t, vt.HTML(), `">req-2b</span>`)
+	})
+
+	t.Run("follows changes and scrolling", func(t *testing.T) {
+		vt := midterm.NewTerminal(2, 10)
+		vt.SetHighlightRules(rules...)
+		mustFprintf(t, vt, "ERROR\r\nok")
+		require.Contains(t, render(t, vt, 0), errorStyle.Render())
+		require.NotContains(t, render(t, vt, 1), errorStyle.Render())
+
+		// the error scrolls off, and the row it was on is matched again
+		mustFprintf(t, vt, "\r\nWARN")
+		require.NotContains(t, render(t, vt, 0), errorStyle.Render())
+		require.Contains(t, render(t, vt, 1), warnStyle.Render())
+
+		// overwriting a row matches it again
+		mustFprintf(t, vt, "\x1b[2;1HERROR")
+		require.Contains(t, render(t, vt, 1), errorStyle.Render())
+		require.NotContains(t, render(t, vt, 1), warnStyle.Render())
+
+		// the alternate screen is matched separately
+		mustFprintf(t, vt, "\x1b[?1049h\x1b[H\x1b[2Jfine")
+		require.NotContains(t, render(t, vt, 1), errorStyle.Render())
+
+		vt.SetHighlightRules()
+		require.Nil(t, vt.HighlightRules())
+	})
+
+	t.Run("later rules win ties", func(t *testing.T) {
+		vt := midterm.NewTerminal(1, 10)
+		vt.SetHighlightRules(
+			midterm.HighlightRule{Pattern: regexp.MustCompile(`ab`), Style: errorStyle},
+			midterm.HighlightRule{Pattern: regexp.MustCompile(`bc`), Style: warnStyle},
+		)
+		mustFprintf(t, vt, "abc")
+		require.Equal(t,
+			errorStyle.Render()+"a"+warnStyle.Render()+"bc"+midterm.EmptyFormat.Render()+"       \x1b[0m",
+			render(t, vt, 0))
+	})
+
+	t.Run("applies to the scrollback", func(t *testing.T) {
+		vt := midterm.NewTerminal(2, 10)
+		vt.Scrollback = &midterm.Scrollback{}
+		vt.SetHighlightRules(rules...)
+		mustFprintf(t, vt, "ERROR\r\nok\r\nok")
+
+		vp := vt.NewViewport(3)
+		var buf strings.Builder
+		require.NoError(t, vp.RenderLine(&buf, 0))
+		require.Contains(t, buf.String(), errorStyle.Render()+"ERROR")
+	})
+}
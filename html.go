@@ -2,7 +2,9 @@ package midterm
 
 import (
 	"bytes"
+	"cmp"
 	"html"
+	"slices"
 )
 
 // HTML renders v as an HTML fragment. One idea for how to use this is to debug
@@ -12,18 +14,75 @@ func (v *Terminal) HTML() string {
 	defer v.mut.Unlock()
 
 	var buf bytes.Buffer
-	buf.WriteString(`<pre style="color:white;background-color:black;">`)
+	buf.WriteString(`<pre style="color:white;background-color:black;`)
+	if len(v.Placements) > 0 {
+		// images are positioned relative to the grid
+		buf.WriteString(`overflow:hidden;position:relative;`)
+	}
+	if v.flashing() {
+		buf.WriteString(`filter:invert(1);`)
+	}
+	buf.WriteString(`">`)
 
 	for y := 0; y < v.Format.Height(); y++ {
 		var x int
+		override := v.rowOverrides(y)
+		links := v.rowLinks(y)
+		// link is the URL of the open link, if any
+		var link string
 		for region := range v.Format.Regions(y) {
-			buf.WriteString(`<span style="` + region.F.css() + `">`)
-			buf.WriteString(html.EscapeString(string(v.Content[y][x : x+region.Size])))
-			buf.WriteString("</span>")
-			x += region.Size
+			end := x + region.Size
+			if override == nil && len(links) == 0 {
+				writeHTMLSpan(&buf, region.F, v.Content[y][x:end])
+				x = end
+				continue
+			}
+			style := func(col int) Format {
+				if override != nil {
+					if f, ok := override(col); ok {
+						return f
+					}
+				}
+				return region.F
+			}
+			// split the region into runs of cells with the same style and link
+			for x < end {
+				f, url := style(x), linkAt(links, x)
+				if url != link {
+					if link != "" {
+						buf.WriteString("</a>")
+					}
+					if url != "" {
+						buf.WriteString(`<a href="` + html.EscapeString(url) + `">`)
+					}
+					link = url
+				}
+				run := x + 1
+				for run < end && style(run) == f && linkAt(links, run) == url {
+					run++
+				}
+				writeHTMLSpan(&buf, f, v.Content[y][x:run])
+				x = run
+			}
+		}
+		if link != "" {
+			buf.WriteString("</a>")
 		}
 		buf.WriteRune('\n')
 	}
+	// draw images in stacking order
+	placements := slices.Clone(v.Placements)
+	slices.SortStableFunc(placements, func(a, b *ImagePlacement) int {
+		return cmp.Compare(a.Z, b.Z)
+	})
+	for _, p := range placements {
+		img, err := imageHTML(p)
+		if err != nil {
+			dbg.Println("HTML: failed to encode image:", err)
+			continue
+		}
+		buf.WriteString(img)
+	}
 	buf.WriteString("</pre>")
 
 	return buf.String()
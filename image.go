@@ -0,0 +1,215 @@
+package midterm
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+)
+
+// ImageProtocol identifies the escape sequence protocol by which an image was
+// transmitted.
+type ImageProtocol int
+
+const (
+	// ImageSixel is a DEC sixel graphic (DCS q).
+	ImageSixel ImageProtocol = iota
+	// ImageKitty is an image placed via the kitty graphics protocol (APC G).
+	ImageKitty
+	// ImageITerm is an iTerm2 inline image (OSC 1337 ; File=).
+	ImageITerm
+)
+
+func (p ImageProtocol) String() string {
+	switch p {
+	case ImageSixel:
+		return "sixel"
+	case ImageKitty:
+		return "kitty"
+	case ImageITerm:
+		return "iterm"
+	default:
+		return fmt.Sprintf("ImageProtocol(%d)", int(p))
+	}
+}
+
+// ImagePlacement is an image displayed on the grid. It is anchored to the cell
+// at its top-left corner so that it scrolls along with the surrounding text.
+type ImagePlacement struct {
+	// Image is the decoded image.
+	Image image.Image
+
+	// Protocol is the protocol the image arrived by.
+	Protocol ImageProtocol
+
+	// Name is the file name of the image, if the protocol provides one.
+	Name string
+
+	// Row and Col are the cell at the top-left corner of the image. Row may be
+	// negative once the top of the image has scrolled off the screen.
+	Row, Col int
+
+	// Rows and Cols are the number of cells covered by the image.
+	Rows, Cols int
+
+	// ID and PlacementID are the image and placement IDs assigned by the
+	// application, for kitty images.
+	ID, PlacementID uint32
+
+	// Z is the stacking order of the image relative to text and other images.
+	// Images with a negative Z are drawn below text.
+	Z int
+}
+
+// Covers reports whether the placement covers the given cell.
+func (p *ImagePlacement) Covers(row, col int) bool {
+	return row >= p.Row && row < p.Row+p.Rows &&
+		col >= p.Col && col < p.Col+p.Cols
+}
+
+// Images returns the images placed on the current screen, in the order that
+// they were placed.
+func (v *Terminal) Images() []ImagePlacement {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	placements := make([]ImagePlacement, len(v.Placements))
+	for i, p := range v.Placements {
+		placements[i] = *p
+	}
+	return placements
+}
+
+// maxImageCells bounds the rows and columns covered by an image, so that a
+// requested size can't make placing it scroll or grow the screen for ever.
+const maxImageCells = 1000
+
+// clampCells limits the rows and columns covered by an image to between 1 and
+// maxImageCells.
+func clampCells(rows, cols int) (int, int) {
+	return min(max(rows, 1), maxImageCells), min(max(cols, 1), maxImageCells)
+}
+
+// cellsFor returns the number of rows and columns needed to display an image of
+// the given pixel size.
+func (v *Terminal) cellsFor(size image.Point) (rows, cols int) {
+	cw, ch := max(v.CellWidth, 1), max(v.CellHeight, 1)
+	return (size.Y + ch - 1) / ch, (size.X + cw - 1) / cw
+}
+
+// placeSixel displays a decoded sixel image at the cursor. Like xterm, the
+// cursor is left on the last row covered by the image, in the column where the
+// image started, scrolling the screen if need be.
+func (v *Terminal) placeSixel(img image.Image) {
+	rows, cols := v.cellsFor(img.Bounds().Size())
+	if rows == 0 || cols == 0 {
+		return
+	}
+	v.wrap = false
+	y, x := v.Cursor.Y, v.Cursor.X
+	v.Placements = append(v.Placements, &ImagePlacement{
+		Image:    img,
+		Protocol: ImageSixel,
+		Row:      y,
+		Col:      x,
+		Rows:     rows,
+		Cols:     cols,
+	})
+	v.imageRowsChanged(y, rows)
+	for i := 1; i < rows; i++ {
+		v.moveDown()
+	}
+	if v.Cursor.Y > v.MaxY {
+		v.MaxY = v.Cursor.Y
+	}
+	if lastX := min(x+cols-1, max(v.Width-1, 0)); lastX > v.MaxX {
+		v.MaxX = lastX
+	}
+}
+
+// imageRowsChanged marks the rows covered by an image placed at row y as
+// changed. The screen only grows to fit the image when AutoResizeY is set;
+// otherwise rows past the bottom are left for moveDown to scroll into view.
+func (v *Terminal) imageRowsChanged(y, rows int) {
+	if !v.AutoResizeY {
+		rows = min(rows, v.Height-y)
+	}
+	for i := 0; i < rows; i++ {
+		v.changed(y+i, false)
+	}
+}
+
+// shiftImages moves the placements anchored within rows start through end by
+// delta rows, discarding those that no longer intersect the range.
+func (s *Screen) shiftImages(start, end, delta int) {
+	if len(s.Placements) == 0 {
+		return
+	}
+	kept := s.Placements[:0]
+	for _, p := range s.Placements {
+		if p.Row+p.Rows > start && p.Row <= end {
+			p.Row += delta
+			if p.Row+p.Rows <= start || p.Row > end ||
+				// rows deleted from the middle of the screen take their
+				// images with them
+				(start > 0 && p.Row < start) {
+				continue
+			}
+		}
+		kept = append(kept, p)
+	}
+	clear(s.Placements[len(kept):])
+	s.Placements = kept
+}
+
+// eraseImages removes placements which overlap the given cells. Like text,
+// images are lost when anything else is written over them, except for kitty
+// images which live on their own layer.
+func (s *Screen) eraseImages(row, col, end int) {
+	if len(s.Placements) == 0 {
+		return
+	}
+	s.deletePlacements(func(p *ImagePlacement) bool {
+		return p.Protocol != ImageKitty &&
+			row >= p.Row && row < p.Row+p.Rows &&
+			col < p.Col+p.Cols && end > p.Col
+	})
+}
+
+// deletePlacements removes the placements which match, returning them.
+func (s *Screen) deletePlacements(match func(*ImagePlacement) bool) []*ImagePlacement {
+	var deleted []*ImagePlacement
+	kept := s.Placements[:0]
+	for _, p := range s.Placements {
+		if match(p) {
+			deleted = append(deleted, p)
+			for i := max(p.Row, 0); i < p.Row+p.Rows && i < len(s.Changes); i++ {
+				s.Changes[i]++
+				s.damaged(i, p.Col, p.Col+p.Cols)
+			}
+			continue
+		}
+		kept = append(kept, p)
+	}
+	clear(s.Placements[len(kept):])
+	s.Placements = kept
+	return deleted
+}
+
+// imageHTML renders a placement as an absolutely positioned <img> with the
+// image inlined as a PNG data URI.
+func imageHTML(p *ImagePlacement) (string, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, p.Image); err != nil {
+		return "", err
+	}
+	var z string
+	if p.Z != 0 {
+		z = fmt.Sprintf("z-index:%d;", p.Z)
+	}
+	return fmt.Sprintf(
+		`<img src="data:image/png;base64,%s" style="position:absolute;left:%dch;top:%dlh;width:%dch;height:%dlh;%s">`,
+		base64.StdEncoding.EncodeToString(buf.Bytes()),
+		p.Col, p.Row, p.Cols, p.Rows, z,
+	), nil
+}
@@ -0,0 +1,113 @@
+package midterm
+
+import (
+	"fmt"
+
+	"github.com/danielgatis/go-ansicode"
+)
+
+// MouseTracking is which mouse events a program has asked to be reported.
+type MouseTracking int
+
+const (
+	// MouseTrackingOff reports no mouse events.
+	MouseTrackingOff MouseTracking = iota
+	// MouseTrackingClicks reports button presses and releases, and the wheel.
+	MouseTrackingClicks
+	// MouseTrackingDrag also reports motion while a button is held.
+	MouseTrackingDrag
+	// MouseTrackingMotion also reports all motion.
+	MouseTrackingMotion
+)
+
+func (t MouseTracking) String() string {
+	switch t {
+	case MouseTrackingOff:
+		return "off"
+	case MouseTrackingClicks:
+		return "clicks"
+	case MouseTrackingDrag:
+		return "drag"
+	case MouseTrackingMotion:
+		return "motion"
+	default:
+		return fmt.Sprintf("MouseTracking(%d)", int(t))
+	}
+}
+
+// InputModes are the modes a program has set which decide how its input
+// should be encoded.
+type InputModes struct {
+	// AppCursorKeys sends the cursor keys as SS3 rather than CSI sequences
+	// (DECCKM).
+	AppCursorKeys bool
+
+	// Mouse is which mouse events to report.
+	Mouse MouseTracking
+
+	// SGRMouse reports mouse events in the SGR encoding rather than the
+	// legacy X10 one.
+	SGRMouse bool
+
+	// BracketedPaste brackets pasted text so it can be told from typing.
+	BracketedPaste bool
+
+	// FocusEvents reports the terminal gaining and losing focus.
+	FocusEvents bool
+}
+
+// mouseModes maps the modes enabling mouse tracking to how much they track.
+var mouseModes = map[ansicode.TerminalMode]MouseTracking{
+	ansicode.TerminalModeReportMouseClicks:     MouseTrackingClicks,
+	ansicode.TerminalModeReportCellMouseMotion: MouseTrackingDrag,
+	ansicode.TerminalModeReportAllMouseMotion:  MouseTrackingMotion,
+}
+
+// setMode records a mode affecting input being set or unset.
+func (m *InputModes) setMode(mode ansicode.TerminalMode, set bool) {
+	switch mode {
+	case ansicode.TerminalModeCursorKeys:
+		m.AppCursorKeys = set
+	case ansicode.TerminalModeReportMouseClicks,
+		ansicode.TerminalModeReportCellMouseMotion,
+		ansicode.TerminalModeReportAllMouseMotion:
+		if set {
+			m.Mouse = mouseModes[mode]
+		} else if m.Mouse == mouseModes[mode] {
+			m.Mouse = MouseTrackingOff
+		}
+	case ansicode.TerminalModeSGRMouse:
+		m.SGRMouse = set
+	case ansicode.TerminalModeBracketedPaste:
+		m.BracketedPaste = set
+	case ansicode.TerminalModeReportFocusInOut:
+		m.FocusEvents = set
+	}
+}
+
+// marshalBinary returns the sequences setting the modes.
+func (m InputModes) marshalBinary() []byte {
+	var modes []ansicode.TerminalMode
+	if m.AppCursorKeys {
+		modes = append(modes, ansicode.TerminalModeCursorKeys)
+	}
+	for mode, tracking := range mouseModes {
+		if m.Mouse == tracking {
+			modes = append(modes, mode)
+		}
+	}
+	if m.SGRMouse {
+		modes = append(modes, ansicode.TerminalModeSGRMouse)
+	}
+	if m.BracketedPaste {
+		modes = append(modes, ansicode.TerminalModeBracketedPaste)
+	}
+	if m.FocusEvents {
+		modes = append(modes, ansicode.TerminalModeReportFocusInOut)
+	}
+	var data []byte
+	for _, mode := range modes {
+		data = fmt.Appendf(data, "\x1b[?%dh", mode)
+	}
+	return data
+}
@@ -0,0 +1,154 @@
+package midterm
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	_ "image/gif"  // register decoders for inline images
+	_ "image/jpeg" // register decoders for inline images
+	"strconv"
+	"strings"
+)
+
+// itermFile handles an iTerm2 inline image, i.e. OSC 1337 ; File=args:data.
+// Only inline files are displayed; anything else would be a download, which
+// is ignored.
+func (v *Terminal) itermFile(payload string) {
+	argsStr, data, ok := strings.Cut(strings.TrimPrefix(payload, "File="), ":")
+	if !ok {
+		dbg.Println("ITermFile: missing data")
+		return
+	}
+	args := map[string]string{}
+	for _, kv := range strings.Split(argsStr, ";") {
+		k, val, _ := strings.Cut(kv, "=")
+		args[k] = val
+	}
+	if args["inline"] != "1" {
+		dbg.Println("ITermFile: not inline (ignored)")
+		return
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		dbg.Println("ITermFile: invalid base64 data:", err)
+		return
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		dbg.Println("ITermFile: failed to decode image:", err)
+		return
+	}
+	if !imageFits(cfg.Width, cfg.Height, v.imageMemoryLimit()) {
+		dbg.Printf("ITermFile: image too large: %dx%d\n", cfg.Width, cfg.Height)
+		return
+	}
+	img, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		dbg.Println("ITermFile: failed to decode image:", err)
+		return
+	}
+
+	var name string
+	if encoded, ok := args["name"]; ok {
+		if decoded, err := base64.StdEncoding.DecodeString(encoded); err == nil {
+			name = string(decoded)
+		}
+	}
+
+	size := img.Bounds().Size()
+	w := v.itermDimension(args["width"], size.X, v.CellWidth, v.Width)
+	h := v.itermDimension(args["height"], size.Y, v.CellHeight, v.Height)
+	preserve := args["preserveAspectRatio"] != "0"
+	switch {
+	case w < 0 && h < 0:
+		w, h = size.X, size.Y
+	case w < 0 && preserve:
+		w = h * size.X / max(size.Y, 1)
+	case w < 0:
+		w = size.X
+	case h < 0 && preserve:
+		h = w * size.Y / max(size.X, 1)
+	case h < 0:
+		h = size.Y
+	case preserve:
+		// fit within the box
+		if w*size.Y > h*size.X {
+			w = h * size.X / max(size.Y, 1)
+		} else {
+			h = w * size.Y / max(size.X, 1)
+		}
+	}
+	rows, cols := clampCells(v.cellsFor(image.Pt(w, h)))
+
+	v.placeITerm(img, name, rows, cols, args["doNotMoveCursor"] == "1")
+}
+
+// itermDimension converts an iTerm2 width or height argument to pixels, or
+// returns -1 for auto. The argument is either N (cells), Npx, N% (of the
+// terminal), or auto.
+func (v *Terminal) itermDimension(arg string, native, cell, cells int) int {
+	var n int
+	var err error
+	switch {
+	case arg == "" || arg == "auto":
+		return -1
+	case strings.HasSuffix(arg, "px"):
+		n, err = strconv.Atoi(strings.TrimSuffix(arg, "px"))
+	case strings.HasSuffix(arg, "%"):
+		n, err = strconv.Atoi(strings.TrimSuffix(arg, "%"))
+		n = n * cells * max(cell, 1) / 100
+	default:
+		n, err = strconv.Atoi(arg)
+		n *= max(cell, 1)
+	}
+	if err != nil || n <= 0 {
+		dbg.Printf("ITermFile: invalid dimension %q\n", arg)
+		return native
+	}
+	return n
+}
+
+// placeITerm displays an inline image at the cursor. Like iTerm2, the image
+// reserves its cells on the grid as if they were printed, leaving the cursor
+// after the image on its last row.
+func (v *Terminal) placeITerm(img image.Image, name string, rows, cols int, noMove bool) {
+	if v.wrap {
+		v.softWrap()
+	}
+	startX := v.Cursor.X
+	if !v.AutoResizeX {
+		cols = min(cols, max(v.Width-startX, 1))
+	}
+	f := v.Cursor.F
+	v.Cursor.F = EmptyFormat
+	for y := 0; y < rows; y++ {
+		if y > 0 {
+			v.wrap = false
+			v.moveDown()
+			v.Cursor.X = startX
+		}
+		for x := 0; x < cols; x++ {
+			v.put(' ')
+		}
+	}
+	v.Cursor.F = f
+
+	// reserving space may have scrolled, so find the top from the bottom
+	top := v.Cursor.Y - (rows - 1)
+	v.Placements = append(v.Placements, &ImagePlacement{
+		Image:    img,
+		Protocol: ImageITerm,
+		Name:     name,
+		Row:      top,
+		Col:      startX,
+		Rows:     rows,
+		Cols:     cols,
+	})
+
+	if noMove {
+		v.wrap = false
+		v.Cursor.X = startX
+		v.Cursor.Y = max(top, 0)
+	}
+}
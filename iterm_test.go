@@ -0,0 +1,123 @@
+package midterm_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vito/midterm"
+)
+
+// itermImage returns an OSC 1337 File= sequence for a w x h PNG.
+func itermImage(t *testing.T, w, h int, args string) string {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	buf := new(bytes.Buffer)
+	require.NoError(t, png.Encode(buf, img))
+	return "\x1b]1337;File=" + args + ":" + base64.StdEncoding.EncodeToString(buf.Bytes()) + "\x07"
+}
+
+func TestITermInlineImage(t *testing.T) {
+	name := base64.StdEncoding.EncodeToString([]byte("chart.png"))
+
+	t.Run("sizes the image from its pixels by default", func(t *testing.T) {
+		vt := midterm.NewTerminal(10, 20)
+		mustFprintf(t, vt, "ab"+itermImage(t, 30, 40, "name="+name+";inline=1"))
+
+		images := vt.Images()
+		require.Len(t, images, 1)
+		img := images[0]
+		require.Equal(t, midterm.ImageITerm, img.Protocol)
+		require.Equal(t, "chart.png", img.Name)
+		require.Equal(t, 0, img.Row)
+		require.Equal(t, 2, img.Col)
+		require.Equal(t, 2, img.Rows)
+		require.Equal(t, 3, img.Cols)
+		require.Equal(t, color.RGBA{255, 255, 255, 255}, color.RGBAModel.Convert(img.Image.At(0, 0)))
+
+		// the cursor is left after the image, on its last row
+		require.Equal(t, 1, vt.Cursor.Y)
+		require.Equal(t, 5, vt.Cursor.X)
+	})
+
+	t.Run("sizes the image from its arguments", func(t *testing.T) {
+		vt := midterm.NewTerminal(10, 20)
+
+		for _, example := range []struct {
+			args       string
+			rows, cols int
+		}{
+			{"width=4;height=3;preserveAspectRatio=0", 3, 4},
+			{"width=40px", 2, 4},
+			{"height=50%", 5, 10},
+			{"width=10;height=2", 2, 4},
+			{"width=30", 15, 20}, // clamped to the screen width
+		} {
+			mustFprintf(t, vt, "\x1b[H\x1b[2J%s", itermImage(t, 100, 100, example.args+";inline=1"))
+			images := vt.Images()
+			require.Len(t, images, 1, example.args)
+			require.Equal(t, example.rows, images[0].Rows, example.args)
+			require.Equal(t, example.cols, images[0].Cols, example.args)
+		}
+	})
+
+	t.Run("reserves space, scrolling if need be", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 20)
+		mustFprintf(t, vt, "hello\r\n\r\n  "+itermImage(t, 10, 60, "inline=1"))
+
+		images := vt.Images()
+		require.Len(t, images, 1)
+		require.Equal(t, 0, images[0].Row)
+		require.Equal(t, 2, images[0].Col)
+		require.Equal(t, 3, images[0].Rows)
+		for _, row := range vt.Content {
+			require.Equal(t, ' ', row[2])
+		}
+	})
+
+	t.Run("bounds the cells an image covers", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 20)
+		mustFprintf(t, vt, itermImage(t, 10, 10, "height=100000000;inline=1"))
+		require.Equal(t, 3, vt.Height)
+		images := vt.Images()
+		require.Len(t, images, 1)
+		require.Equal(t, 1000, images[0].Rows)
+	})
+
+	t.Run("ignores images too large to decode", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 20)
+		vt.ImageMemoryLimit = 10 * 10 * 4
+		mustFprintf(t, vt, itermImage(t, 100, 100, "inline=1"))
+		require.Empty(t, vt.Images())
+		require.Equal(t, 0, vt.Cursor.X)
+	})
+
+	t.Run("can leave the cursor in place", func(t *testing.T) {
+		vt := midterm.NewTerminal(5, 20)
+		mustFprintf(t, vt, "ab"+itermImage(t, 30, 40, "inline=1;doNotMoveCursor=1"))
+		require.Equal(t, 0, vt.Cursor.Y)
+		require.Equal(t, 2, vt.Cursor.X)
+	})
+
+	t.Run("ignores downloads", func(t *testing.T) {
+		vt := midterm.NewTerminal(5, 20)
+		mustFprintf(t, vt, itermImage(t, 30, 40, "name="+name))
+		require.Empty(t, vt.Images())
+	})
+
+	t.Run("is rendered into HTML", func(t *testing.T) {
+		vt := midterm.NewTerminal(5, 20)
+		mustFprintf(t, vt, itermImage(t, 30, 40, "inline=1"))
+		html := vt.HTML()
+		require.Equal(t, 1, strings.Count(html, "<img "))
+		require.Contains(t, html, `left:0ch;top:0lh;width:3ch;height:2lh;`)
+	})
+}
@@ -0,0 +1,591 @@
+package midterm
+
+import (
+	"bytes"
+	"cmp"
+	"compress/zlib"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DefaultImageMemoryLimit is the default value for Terminal.ImageMemoryLimit,
+// matching kitty's own default storage quota.
+const DefaultImageMemoryLimit = 320 << 20
+
+// kittyCommand is a parsed kitty graphics protocol command.
+type kittyCommand struct {
+	action      byte // a
+	quiet       int  // q
+	format      int  // f
+	medium      byte // t
+	compression byte // o
+	more        bool // m
+
+	// pixel dimensions of raw image data
+	width, height int // s, v
+
+	id, number, placement uint32 // i, I, p
+
+	// source rectangle for placements, or the cell for deletions
+	x, y, w, h int // x, y, w, h
+
+	cols, rows int  // c, r
+	noMove     bool // C
+	z          int  // z
+	delete     byte // d
+
+	payload []byte
+
+	// anonymous indicates that neither i= nor I= was specified, in which case
+	// no response is sent even if the terminal assigns an ID.
+	anonymous bool
+}
+
+func parseKittyCommand(data []byte) (*kittyCommand, error) {
+	cmd := &kittyCommand{
+		action: 't',
+		format: 32,
+		medium: 'd',
+		delete: 'a',
+	}
+	control, payload, _ := bytes.Cut(data, []byte{';'})
+	cmd.payload = payload
+	for _, kv := range strings.Split(string(control), ",") {
+		if kv == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(kv, "=")
+		if !ok || len(key) != 1 || val == "" {
+			return nil, fmt.Errorf("malformed key: %q", kv)
+		}
+		switch key[0] {
+		case 'a':
+			cmd.action = val[0]
+			continue
+		case 't':
+			cmd.medium = val[0]
+			continue
+		case 'o':
+			cmd.compression = val[0]
+			continue
+		case 'd':
+			cmd.delete = val[0]
+			continue
+		}
+		n, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed value for %s: %q", key, val)
+		}
+		switch key[0] {
+		case 'q':
+			cmd.quiet = int(n)
+		case 'f':
+			cmd.format = int(n)
+		case 'm':
+			cmd.more = n == 1
+		case 's':
+			cmd.width = int(n)
+		case 'v':
+			cmd.height = int(n)
+		case 'i':
+			cmd.id = uint32(n)
+		case 'I':
+			cmd.number = uint32(n)
+		case 'p':
+			cmd.placement = uint32(n)
+		case 'x':
+			cmd.x = int(n)
+		case 'y':
+			cmd.y = int(n)
+		case 'w':
+			cmd.w = int(n)
+		case 'h':
+			cmd.h = int(n)
+		case 'c':
+			cmd.cols = int(n)
+		case 'r':
+			cmd.rows = int(n)
+		case 'C':
+			cmd.noMove = n == 1
+		case 'z':
+			cmd.z = int(n)
+		}
+	}
+	cmd.anonymous = cmd.id == 0 && cmd.number == 0
+	return cmd, nil
+}
+
+// kittyError is an error reported back to the application, prefixed with a
+// POSIX-style error code.
+type kittyError struct {
+	code, msg string
+}
+
+func (err kittyError) Error() string {
+	return err.code + ":" + err.msg
+}
+
+// kittyImages stores images transmitted via the kitty graphics protocol.
+type kittyImages struct {
+	images  map[uint32]*kittyImage
+	numbers map[uint32]uint32
+
+	// used is the number of bytes used by all stored images.
+	used int
+
+	// lastID is the last image ID assigned by the terminal.
+	lastID uint32
+
+	// seq orders images by transmission, for evicting the oldest.
+	seq uint64
+
+	// pending is a transmission still receiving chunks.
+	pending *kittyCommand
+}
+
+type kittyImage struct {
+	id     uint32
+	number uint32
+	img    image.Image
+	size   int
+	seq    uint64
+}
+
+// kittyGraphics handles a kitty graphics protocol command, i.e. the content
+// of an APC G string.
+func (v *Terminal) kittyGraphics(data []byte) {
+	if v.kitty == nil {
+		v.kitty = &kittyImages{
+			images:  map[uint32]*kittyImage{},
+			numbers: map[uint32]uint32{},
+		}
+	}
+	store := v.kitty
+
+	if pending := store.pending; pending != nil {
+		// continuation chunks only carry m= and q=
+		cmd, err := parseKittyCommand(data)
+		if err != nil {
+			store.pending = nil
+			v.kittyReply(pending, kittyError{"EINVAL", err.Error()})
+			return
+		}
+		pending.payload = append(pending.payload, cmd.payload...)
+		if len(pending.payload) > v.imageMemoryLimit() {
+			store.pending = nil
+			v.kittyReply(pending, kittyError{"EFBIG", "image too large"})
+			return
+		}
+		if cmd.more {
+			return
+		}
+		store.pending = nil
+		v.kittyCommand(pending)
+		return
+	}
+
+	cmd, err := parseKittyCommand(data)
+	if err != nil {
+		v.kittyReply(&kittyCommand{}, kittyError{"EINVAL", err.Error()})
+		return
+	}
+	dbg.Printf("KittyGraphics: a=%c i=%d I=%d p=%d m=%v\n", cmd.action, cmd.id, cmd.number, cmd.placement, cmd.more)
+	if cmd.more && (cmd.action == 't' || cmd.action == 'T' || cmd.action == 'q') {
+		cmd.payload = append([]byte(nil), cmd.payload...)
+		store.pending = cmd
+		return
+	}
+	v.kittyCommand(cmd)
+}
+
+func (v *Terminal) kittyCommand(cmd *kittyCommand) {
+	store := v.kitty
+	switch cmd.action {
+	case 't', 'T':
+		if cmd.id != 0 && cmd.number != 0 {
+			v.kittyReply(cmd, kittyError{"EINVAL", "must not specify both i and I"})
+			return
+		}
+		img, err := cmd.decode(v.imageMemoryLimit())
+		if err != nil {
+			v.kittyReply(cmd, err)
+			return
+		}
+		if cmd.id == 0 {
+			cmd.id = store.nextID()
+		}
+		v.storeKittyImage(cmd.id, cmd.number, img)
+		if cmd.action == 'T' {
+			if err := v.placeKitty(cmd); err != nil {
+				v.kittyReply(cmd, err)
+				return
+			}
+		}
+		v.kittyReply(cmd, nil)
+	case 'q':
+		_, err := cmd.decode(v.imageMemoryLimit())
+		v.kittyReply(cmd, err)
+	case 'p':
+		v.kittyReply(cmd, v.placeKitty(cmd))
+	case 'd':
+		v.kittyDelete(cmd)
+	default:
+		v.kittyReply(cmd, kittyError{"EINVAL", fmt.Sprintf("unsupported action: %c", cmd.action)})
+	}
+}
+
+// decode decodes the command's payload into an image, refusing images which
+// would take more than limit bytes once decoded.
+func (cmd *kittyCommand) decode(limit int) (image.Image, error) {
+	if cmd.medium != 'd' {
+		return nil, kittyError{"EINVAL", fmt.Sprintf("unsupported transmission medium: %c", cmd.medium)}
+	}
+	data, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(string(cmd.payload), "="))
+	if err != nil {
+		return nil, kittyError{"EINVAL", "invalid base64 data"}
+	}
+	switch cmd.compression {
+	case 0:
+	case 'z':
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, kittyError{"EINVAL", "invalid zlib data"}
+		}
+		data, err = io.ReadAll(io.LimitReader(zr, int64(limit)+1))
+		if err != nil {
+			return nil, kittyError{"EINVAL", "invalid zlib data"}
+		}
+		if len(data) > limit {
+			return nil, kittyError{"EFBIG", "image too large"}
+		}
+	default:
+		return nil, kittyError{"EINVAL", fmt.Sprintf("unsupported compression: %c", cmd.compression)}
+	}
+	switch cmd.format {
+	case 24, 32:
+		bpp := cmd.format / 8
+		if cmd.width <= 0 || cmd.height <= 0 {
+			return nil, kittyError{"EINVAL", "image dimensions required"}
+		}
+		if !imageFits(cmd.width, cmd.height, limit) {
+			return nil, kittyError{"EFBIG", "image too large"}
+		}
+		if len(data) < cmd.width*cmd.height*bpp {
+			return nil, kittyError{"ENODATA", "insufficient image data"}
+		}
+		img := image.NewNRGBA(image.Rect(0, 0, cmd.width, cmd.height))
+		for i, j := 0, 0; i < len(img.Pix); i, j = i+4, j+bpp {
+			copy(img.Pix[i:i+3], data[j:j+3])
+			if bpp == 4 {
+				img.Pix[i+3] = data[j+3]
+			} else {
+				img.Pix[i+3] = 255
+			}
+		}
+		return img, nil
+	case 100:
+		cfg, err := png.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, kittyError{"EBADPNG", err.Error()}
+		}
+		if !imageFits(cfg.Width, cfg.Height, limit) {
+			return nil, kittyError{"EFBIG", "image too large"}
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, kittyError{"EBADPNG", err.Error()}
+		}
+		return img, nil
+	default:
+		return nil, kittyError{"EINVAL", fmt.Sprintf("unsupported format: %d", cmd.format)}
+	}
+}
+
+// imageFits reports whether an RGBA image of the given dimensions fits in
+// limit bytes, without overflowing.
+func imageFits(width, height, limit int) bool {
+	return width > 0 && height > 0 && width <= limit/4/height
+}
+
+func (store *kittyImages) nextID() uint32 {
+	for {
+		store.lastID++
+		if store.lastID == 0 {
+			continue
+		}
+		if _, taken := store.images[store.lastID]; !taken {
+			return store.lastID
+		}
+	}
+}
+
+func (v *Terminal) imageMemoryLimit() int {
+	if v.ImageMemoryLimit > 0 {
+		return v.ImageMemoryLimit
+	}
+	return DefaultImageMemoryLimit
+}
+
+// storeKittyImage stores an image, replacing any with the same ID and evicting
+// the oldest images if the memory limit is exceeded.
+func (v *Terminal) storeKittyImage(id, number uint32, img image.Image) {
+	store := v.kitty
+	if old, ok := store.images[id]; ok {
+		v.removeKittyImage(old)
+	}
+	size := img.Bounds().Dx() * img.Bounds().Dy() * 4
+	store.seq++
+	store.images[id] = &kittyImage{
+		id:     id,
+		number: number,
+		img:    img,
+		size:   size,
+		seq:    store.seq,
+	}
+	store.used += size
+	if number != 0 {
+		store.numbers[number] = id
+	}
+
+	if store.used <= v.imageMemoryLimit() {
+		return
+	}
+	// evict the oldest images, starting with those that aren't displayed
+	byAge := make([]*kittyImage, 0, len(store.images))
+	for _, stored := range store.images {
+		if stored.id != id {
+			byAge = append(byAge, stored)
+		}
+	}
+	slices.SortFunc(byAge, func(a, b *kittyImage) int {
+		aPlaced, bPlaced := v.kittyPlaced(a.id), v.kittyPlaced(b.id)
+		if aPlaced != bPlaced {
+			if aPlaced {
+				return 1
+			}
+			return -1
+		}
+		return cmp.Compare(a.seq, b.seq)
+	})
+	for _, stored := range byAge {
+		if store.used <= v.imageMemoryLimit() {
+			break
+		}
+		dbg.Printf("KittyGraphics: evicting image %d\n", stored.id)
+		v.removeKittyImage(stored)
+	}
+}
+
+// removeKittyImage frees an image along with all of its placements.
+func (v *Terminal) removeKittyImage(img *kittyImage) {
+	store := v.kitty
+	delete(store.images, img.id)
+	if img.number != 0 && store.numbers[img.number] == img.id {
+		delete(store.numbers, img.number)
+	}
+	store.used -= img.size
+	isImage := func(p *ImagePlacement) bool {
+		return p.Protocol == ImageKitty && p.ID == img.id
+	}
+	v.Screen.deletePlacements(isImage)
+	if v.Alt != nil {
+		v.Alt.deletePlacements(isImage)
+	}
+}
+
+// kittyPlaced reports whether an image is displayed on either screen.
+func (v *Terminal) kittyPlaced(id uint32) bool {
+	for _, s := range []*Screen{v.Screen, v.Alt} {
+		if s == nil {
+			continue
+		}
+		for _, p := range s.Placements {
+			if p.Protocol == ImageKitty && p.ID == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lookup finds the image referred to by a command's i= or I= key.
+func (store *kittyImages) lookup(cmd *kittyCommand) *kittyImage {
+	id := cmd.id
+	if id == 0 && cmd.number != 0 {
+		id = store.numbers[cmd.number]
+	}
+	return store.images[id]
+}
+
+// placeKitty displays a stored image at the cursor.
+func (v *Terminal) placeKitty(cmd *kittyCommand) error {
+	stored := v.kitty.lookup(cmd)
+	if stored == nil {
+		return kittyError{"ENOENT", "image not found"}
+	}
+	cmd.id = stored.id
+
+	img := stored.img
+	bounds := img.Bounds()
+	src := image.Rect(cmd.x, cmd.y, bounds.Max.X, bounds.Max.Y)
+	if cmd.w > 0 {
+		src.Max.X = cmd.x + cmd.w
+	}
+	if cmd.h > 0 {
+		src.Max.Y = cmd.y + cmd.h
+	}
+	src = src.Intersect(bounds)
+	if src.Empty() {
+		return kittyError{"EINVAL", "source rectangle is empty"}
+	}
+	if src != bounds {
+		if sub, ok := img.(interface {
+			SubImage(image.Rectangle) image.Image
+		}); ok {
+			img = sub.SubImage(src)
+		}
+	}
+
+	rows, cols := cmd.rows, cmd.cols
+	switch {
+	case rows == 0 && cols == 0:
+		rows, cols = v.cellsFor(src.Size())
+	case rows == 0:
+		// scale to the given width, keeping the aspect ratio
+		px := cols * max(v.CellWidth, 1) * src.Dy() / src.Dx()
+		rows, _ = v.cellsFor(image.Pt(0, px))
+	case cols == 0:
+		px := rows * max(v.CellHeight, 1) * src.Dx() / src.Dy()
+		_, cols = v.cellsFor(image.Pt(px, 0))
+	}
+	rows, cols = clampCells(rows, cols)
+
+	if cmd.placement != 0 {
+		// placing with the same ID again moves the placement
+		v.Screen.deletePlacements(func(p *ImagePlacement) bool {
+			return p.Protocol == ImageKitty && p.ID == cmd.id && p.PlacementID == cmd.placement
+		})
+	}
+
+	y, x := v.Cursor.Y, v.Cursor.X
+	v.Placements = append(v.Placements, &ImagePlacement{
+		Image:       img,
+		Protocol:    ImageKitty,
+		ID:          cmd.id,
+		PlacementID: cmd.placement,
+		Z:           cmd.z,
+		Row:         y,
+		Col:         x,
+		Rows:        rows,
+		Cols:        cols,
+	})
+	v.imageRowsChanged(y, rows)
+	if !cmd.noMove {
+		// move to the right of the image, on its last row
+		v.wrap = false
+		for i := 1; i < rows; i++ {
+			v.moveDown()
+		}
+		v.moveAbs(v.Cursor.Y, min(x+cols, max(v.Width-1, 0)))
+		if v.Cursor.Y > v.MaxY {
+			v.MaxY = v.Cursor.Y
+		}
+	}
+	return nil
+}
+
+// kittyDelete handles the delete action. Lowercase specifiers only delete
+// placements; uppercase ones also free the images which are no longer
+// displayed.
+func (v *Terminal) kittyDelete(cmd *kittyCommand) {
+	store := v.kitty
+	var match func(*ImagePlacement) bool
+	switch cmd.delete | 0x20 { // lowercase
+	case 'a':
+		match = func(*ImagePlacement) bool { return true }
+	case 'i', 'n':
+		stored := store.lookup(cmd)
+		if stored == nil {
+			return
+		}
+		match = func(p *ImagePlacement) bool {
+			return p.ID == stored.id && (cmd.placement == 0 || p.PlacementID == cmd.placement)
+		}
+	case 'c':
+		y, x := v.Cursor.Y, v.Cursor.X
+		match = func(p *ImagePlacement) bool { return p.Covers(y, x) }
+	case 'p':
+		match = func(p *ImagePlacement) bool { return p.Covers(cmd.y-1, cmd.x-1) }
+	case 'q':
+		match = func(p *ImagePlacement) bool { return p.Covers(cmd.y-1, cmd.x-1) && p.Z == cmd.z }
+	case 'x':
+		match = func(p *ImagePlacement) bool { return cmd.x-1 >= p.Col && cmd.x-1 < p.Col+p.Cols }
+	case 'y':
+		match = func(p *ImagePlacement) bool { return cmd.y-1 >= p.Row && cmd.y-1 < p.Row+p.Rows }
+	case 'z':
+		match = func(p *ImagePlacement) bool { return p.Z == cmd.z }
+	default:
+		v.kittyReply(cmd, kittyError{"EINVAL", fmt.Sprintf("unsupported delete: %c", cmd.delete)})
+		return
+	}
+
+	deleted := v.Screen.deletePlacements(func(p *ImagePlacement) bool {
+		return p.Protocol == ImageKitty && match(p)
+	})
+	if cmd.delete >= 'a' {
+		return
+	}
+	for _, p := range deleted {
+		if stored, ok := store.images[p.ID]; ok && !v.kittyPlaced(p.ID) {
+			v.removeKittyImage(stored)
+		}
+	}
+	if cmd.delete == 'I' || cmd.delete == 'N' {
+		// free the image even if it was never placed
+		if stored := store.lookup(cmd); stored != nil && !v.kittyPlaced(stored.id) {
+			v.removeKittyImage(stored)
+		}
+	}
+}
+
+// kittyReply responds to a command, unless it has no ID to respond to or has
+// asked to be quiet.
+func (v *Terminal) kittyReply(cmd *kittyCommand, err error) {
+	if err != nil {
+		dbg.Printf("KittyGraphics: %s\n", err)
+	}
+	if cmd.anonymous || cmd.id == 0 && cmd.number == 0 {
+		return
+	}
+	if err == nil && cmd.quiet >= 1 || err != nil && cmd.quiet >= 2 {
+		return
+	}
+	if v.ForwardResponses == nil {
+		dbg.Println("KittyGraphics: NO RESPONSE CHANNEL")
+		return
+	}
+	keys := []string{}
+	if cmd.id != 0 {
+		keys = append(keys, fmt.Sprintf("i=%d", cmd.id))
+	}
+	if cmd.number != 0 {
+		keys = append(keys, fmt.Sprintf("I=%d", cmd.number))
+	}
+	if cmd.placement != 0 {
+		keys = append(keys, fmt.Sprintf("p=%d", cmd.placement))
+	}
+	msg := "OK"
+	if err != nil {
+		msg = err.Error()
+		if _, ok := err.(kittyError); !ok {
+			msg = "EINVAL:" + msg
+		}
+	}
+	v.respond("\x1b_G%s;%s\x1b\\", strings.Join(keys, ","), msg)
+}
@@ -0,0 +1,211 @@
+package midterm_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vito/midterm"
+)
+
+// kittyRGBA returns base64 RGBA data for a w x h image of a single color.
+func kittyRGBA(w, h int, c color.NRGBA) string {
+	data := bytes.Repeat([]byte{c.R, c.G, c.B, c.A}, w*h)
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+func TestKittyGraphics(t *testing.T) {
+	green := color.NRGBA{0, 255, 0, 255}
+
+	t.Run("transmits and displays an image", func(t *testing.T) {
+		vt := midterm.NewTerminal(5, 20)
+		responses := new(bytes.Buffer)
+		vt.ForwardResponses = responses
+
+		mustFprintf(t, vt, "ab\x1b_Ga=T,f=32,s=20,v=40,i=7;%s\x1b\\", kittyRGBA(20, 40, green))
+		require.Equal(t, "\x1b_Gi=7;OK\x1b\\", responses.String())
+
+		images := vt.Images()
+		require.Len(t, images, 1)
+		img := images[0]
+		require.Equal(t, midterm.ImageKitty, img.Protocol)
+		require.Equal(t, uint32(7), img.ID)
+		require.Equal(t, 0, img.Row)
+		require.Equal(t, 2, img.Col)
+		require.Equal(t, 2, img.Rows)
+		require.Equal(t, 2, img.Cols)
+		require.Equal(t, green, img.Image.At(0, 0))
+
+		// the cursor moves to the right of the image, on its last row
+		require.Equal(t, 1, vt.Cursor.Y)
+		require.Equal(t, 4, vt.Cursor.X)
+	})
+
+	t.Run("reassembles chunked transmissions", func(t *testing.T) {
+		vt := midterm.NewTerminal(5, 20)
+		responses := new(bytes.Buffer)
+		vt.ForwardResponses = responses
+
+		data := kittyRGBA(10, 20, green)
+		mustFprintf(t, vt, "\x1b_Ga=t,f=32,s=10,v=20,i=1,m=1;%s\x1b\\", data[:400])
+		mustFprintf(t, vt, "\x1b_Gm=1;%s\x1b\\", data[400:800])
+		require.Empty(t, responses.String())
+		mustFprintf(t, vt, "\x1b_Gm=0;%s\x1b\\", data[800:])
+		require.Equal(t, "\x1b_Gi=1;OK\x1b\\", responses.String())
+		require.Empty(t, vt.Images())
+
+		responses.Reset()
+		mustFprintf(t, vt, "\x1b_Ga=p,i=1,p=3,c=4,r=2,C=1\x1b\\")
+		require.Equal(t, "\x1b_Gi=1,p=3;OK\x1b\\", responses.String())
+		images := vt.Images()
+		require.Len(t, images, 1)
+		require.Equal(t, uint32(3), images[0].PlacementID)
+		require.Equal(t, 4, images[0].Cols)
+		require.Equal(t, 2, images[0].Rows)
+		require.Equal(t, 0, vt.Cursor.X)
+		require.Equal(t, 0, vt.Cursor.Y)
+	})
+
+	t.Run("reports errors", func(t *testing.T) {
+		vt := midterm.NewTerminal(5, 20)
+		responses := new(bytes.Buffer)
+		vt.ForwardResponses = responses
+
+		mustFprintf(t, vt, "\x1b_Ga=p,i=42\x1b\\")
+		require.True(t, strings.HasPrefix(responses.String(), "\x1b_Gi=42;ENOENT:"), "%q", responses.String())
+
+		responses.Reset()
+		mustFprintf(t, vt, "\x1b_Ga=q,f=32,s=10,v=10,i=5;%s\x1b\\", kittyRGBA(1, 1, green))
+		require.True(t, strings.HasPrefix(responses.String(), "\x1b_Gi=5;ENODATA:"), "%q", responses.String())
+
+		responses.Reset()
+		mustFprintf(t, vt, "\x1b_Ga=q,f=32,s=1,v=1,i=5,q=2;xx\x1b\\")
+		require.Empty(t, responses.String())
+	})
+
+	t.Run("deletes placements and images", func(t *testing.T) {
+		vt := midterm.NewTerminal(5, 20)
+		responses := new(bytes.Buffer)
+		vt.ForwardResponses = responses
+
+		mustFprintf(t, vt, "\x1b_Ga=T,s=10,v=20,i=1,q=1;%s\x1b\\", kittyRGBA(10, 20, green))
+		mustFprintf(t, vt, "\x1b_Ga=T,s=10,v=20,i=2,q=1;%s\x1b\\", kittyRGBA(10, 20, green))
+		require.Len(t, vt.Images(), 2)
+
+		mustFprintf(t, vt, "\x1b_Ga=d,d=i,i=1\x1b\\")
+		images := vt.Images()
+		require.Len(t, images, 1)
+		require.Equal(t, uint32(2), images[0].ID)
+
+		// lowercase keeps the image data around, so it can be placed again
+		mustFprintf(t, vt, "\x1b_Ga=p,i=1\x1b\\")
+		require.Len(t, vt.Images(), 2)
+
+		// uppercase frees it
+		mustFprintf(t, vt, "\x1b_Ga=d,d=I,i=1\x1b\\")
+		mustFprintf(t, vt, "\x1b_Ga=p,i=1\x1b\\")
+		require.Contains(t, responses.String(), "ENOENT")
+		require.Len(t, vt.Images(), 1)
+
+		mustFprintf(t, vt, "\x1b_Ga=d\x1b\\")
+		require.Empty(t, vt.Images())
+	})
+
+	t.Run("evicts the oldest images past the memory limit", func(t *testing.T) {
+		vt := midterm.NewTerminal(5, 20)
+		responses := new(bytes.Buffer)
+		vt.ForwardResponses = responses
+		vt.ImageMemoryLimit = 2 * 10 * 10 * 4
+
+		for i := 1; i <= 3; i++ {
+			mustFprintf(t, vt, "\x1b_Ga=t,s=10,v=10,i=%d,q=1;%s\x1b\\", i, kittyRGBA(10, 10, green))
+		}
+		for i := 1; i <= 3; i++ {
+			mustFprintf(t, vt, "\x1b_Ga=p,i=%d,C=1,q=1\x1b\\", i)
+		}
+		require.Equal(t, fmt.Sprintf("\x1b_Gi=%d;ENOENT:image not found\x1b\\", 1), responses.String())
+		require.Len(t, vt.Images(), 2)
+	})
+
+	t.Run("rejects images too large to decode", func(t *testing.T) {
+		vt := midterm.NewTerminal(5, 20)
+		responses := new(bytes.Buffer)
+		vt.ForwardResponses = responses
+		vt.ImageMemoryLimit = 10 * 10 * 4
+
+		mustFprintf(t, vt, "\x1b_Ga=T,f=24,s=4294967296,v=4294967296,i=1;AAAA\x1b\\")
+		require.Equal(t, "\x1b_Gi=1;EFBIG:image too large\x1b\\", responses.String())
+
+		responses.Reset()
+		var zdata bytes.Buffer
+		zw := zlib.NewWriter(&zdata)
+		_, err := zw.Write(make([]byte, 1<<20))
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+		mustFprintf(t, vt, "\x1b_Ga=T,f=32,o=z,s=512,v=512,i=2;%s\x1b\\", base64.StdEncoding.EncodeToString(zdata.Bytes()))
+		require.Equal(t, "\x1b_Gi=2;EFBIG:image too large\x1b\\", responses.String())
+
+		responses.Reset()
+		var pngData bytes.Buffer
+		require.NoError(t, png.Encode(&pngData, image.NewNRGBA(image.Rect(0, 0, 100, 100))))
+		mustFprintf(t, vt, "\x1b_Ga=T,f=100,i=3;%s\x1b\\", base64.StdEncoding.EncodeToString(pngData.Bytes()))
+		require.Equal(t, "\x1b_Gi=3;EFBIG:image too large\x1b\\", responses.String())
+
+		require.Empty(t, vt.Images())
+	})
+
+	t.Run("scrolls with the content, but survives text", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 20)
+		mustFprintf(t, vt, "\x1b_Ga=T,s=10,v=20,C=1;%s\x1b\\", kittyRGBA(10, 20, green))
+		mustFprintf(t, vt, "over the image")
+		require.Len(t, vt.Images(), 1)
+
+		mustFprintf(t, vt, "\r\n\r\n")
+		require.Equal(t, 0, vt.Images()[0].Row)
+		mustFprintf(t, vt, "\r\n")
+		require.Empty(t, vt.Images())
+	})
+
+	t.Run("scrolls a fixed-size screen instead of growing it", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 20)
+		vt.CellWidth, vt.CellHeight = 10, 20
+		mustFprintf(t, vt, "\r\n\x1b_Ga=T,s=1,v=100,q=1;%s\x1b\\", kittyRGBA(1, 100, green))
+
+		require.Equal(t, 3, vt.Height)
+		require.Len(t, vt.Content, 3)
+		require.Equal(t, 2, vt.Cursor.Y)
+		images := vt.Images()
+		require.Len(t, images, 1)
+		require.Equal(t, -2, images[0].Row)
+		require.Equal(t, 5, images[0].Rows)
+	})
+
+	t.Run("bounds the cells an image covers", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 20)
+		mustFprintf(t, vt, "\x1b_Ga=T,s=1,v=1,r=2000000000,c=2000000000,q=1;%s\x1b\\", kittyRGBA(1, 1, green))
+		require.Equal(t, 3, vt.Height)
+		images := vt.Images()
+		require.Len(t, images, 1)
+		require.Equal(t, 1000, images[0].Rows)
+		require.Equal(t, 1000, images[0].Cols)
+
+		vt = midterm.NewAutoResizingTerminal()
+		mustFprintf(t, vt, "\x1b_Ga=T,s=1,v=1,r=2000000000,q=1;%s\x1b\\", kittyRGBA(1, 1, green))
+		require.Equal(t, 1000, vt.Height)
+	})
+
+	t.Run("is cleared with the screen", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 20)
+		mustFprintf(t, vt, "\x1b_Ga=T,s=10,v=20;%s\x1b\\", kittyRGBA(10, 20, green))
+		require.Len(t, vt.Images(), 1)
+		mustFprintf(t, vt, "\x1b[2J")
+		require.Empty(t, vt.Images())
+	})
+}
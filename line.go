@@ -0,0 +1,42 @@
+package midterm
+
+import "time"
+
+// LineInfo is metadata about a row of the screen.
+type LineInfo struct {
+	// Wrapped indicates that the row was soft-wrapped, i.e. printing text ran
+	// past the end of the row and continued onto the next one. Rows joined
+	// this way form a single logical line, which is reflowed when the width
+	// of the main screen changes.
+	Wrapped bool
+
+	// Written is when text was last printed to the row.
+	Written time.Time
+
+	// Tags are arbitrary key-value pairs attached to the row by the user via
+	// SetLineTag.
+	Tags map[string]string
+}
+
+// SetLineTag attaches a tag to a row of the current screen, which travels
+// with the row as it scrolls and is delivered along with it to OnScrollback.
+// An empty value removes the tag.
+func (v *Terminal) SetLineTag(row int, key, value string) {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	if row < 0 || row >= len(v.Info) {
+		return
+	}
+	info := &v.Info[row]
+	if value == "" {
+		delete(info.Tags, key)
+		if len(info.Tags) == 0 {
+			info.Tags = nil
+		}
+		return
+	}
+	if info.Tags == nil {
+		info.Tags = map[string]string{}
+	}
+	info.Tags[key] = value
+}
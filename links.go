@@ -0,0 +1,333 @@
+package midterm
+
+import (
+	"cmp"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// LinkKind is the kind of text a Link was detected as.
+type LinkKind int
+
+const (
+	// LinkURL is a URL, e.g. https://example.com/.
+	LinkURL LinkKind = iota
+	// LinkFile is a reference to a line of a file, e.g. main.go:12:5.
+	LinkFile
+	// LinkHash is a hexadecimal hash, e.g. a git commit.
+	LinkHash
+)
+
+func (k LinkKind) String() string {
+	switch k {
+	case LinkURL:
+		return "url"
+	case LinkFile:
+		return "file"
+	case LinkHash:
+		return "hash"
+	default:
+		return fmt.Sprintf("LinkKind(%d)", int(k))
+	}
+}
+
+// Link is text on the screen detected as something which could be opened,
+// whether or not the program printing it marked it as a hyperlink.
+type Link struct {
+	Kind LinkKind
+
+	// Text is the text of the link.
+	Text string
+
+	// URL is where the link leads. It is empty if unknown, e.g. for hashes,
+	// or for relative paths before the shell has reported a working directory.
+	URL string
+
+	// Path, Line and Col are the parts of a file reference. Col is zero if
+	// not given.
+	Path      string
+	Line, Col int
+
+	// Range is the cells of the link, which may span soft-wrapped rows.
+	Range Range
+}
+
+var (
+	linkURLPattern  = regexp.MustCompile("\\b(?:https?|ftp|file)://[^\\s<>\"'`]+")
+	linkFilePattern = regexp.MustCompile(`(?:[\w.~+@-]*/)*[\w.+@-]*\.[A-Za-z]\w*:(\d+)(?::(\d+))?`)
+	linkHashPattern = regexp.MustCompile(`\b[0-9a-f]{7,64}\b`)
+)
+
+// Links returns the links detected on the screen, in order.
+func (v *Terminal) Links() []Link {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	var links []Link
+	for row := 0; row < len(v.Content); {
+		start, end := v.wrappedRows(row)
+		links = append(links, v.detectLinks(start, end)...)
+		row = end + 1
+	}
+	return links
+}
+
+// LinkAt returns the link detected at pos, if any.
+func (v *Terminal) LinkAt(pos Position) (Link, bool) {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	row := pos.Line - v.scrolled()
+	if row < 0 || row >= len(v.Content) {
+		return Link{}, false
+	}
+	start, end := v.wrappedRows(row)
+	for _, link := range v.detectLinks(start, end) {
+		if from, to, ok := link.Range.cols(pos.Line); ok && pos.Col >= from && pos.Col < to {
+			return link, true
+		}
+	}
+	return Link{}, false
+}
+
+// wrappedRows returns the first and last rows of the screen soft-wrapped
+// together with row.
+func (v *Terminal) wrappedRows(row int) (int, int) {
+	start, end := row, row
+	for start > 0 && v.Info[start-1].Wrapped {
+		start--
+	}
+	for end < len(v.Content)-1 && v.Info[end].Wrapped {
+		end++
+	}
+	return start, end
+}
+
+// detectLinks finds the links in the line soft-wrapped across the rows from
+// start through end.
+func (v *Terminal) detectLinks(start, end int) []Link {
+	var line []rune
+	var sizes []int
+	for row := start; row <= end; row++ {
+		line = append(line, v.Content[row]...)
+		sizes = append(sizes, len(v.Content[row]))
+	}
+	text := string(line)
+
+	// pos returns the position of the cell of the rune at byte offset i
+	pos := func(i int) Position {
+		n := utf8.RuneCountInString(text[:i])
+		row := start
+		for row < end && n >= sizes[row-start] {
+			n -= sizes[row-start]
+			row++
+		}
+		return v.position(row, n)
+	}
+
+	// earlier kinds of links take precedence where they overlap
+	var links []Link
+	var offsets [][2]int
+	overlaps := func(from, to int) bool {
+		for _, o := range offsets {
+			if from < o[1] && to > o[0] {
+				return true
+			}
+		}
+		return false
+	}
+	add := func(from, to int, link Link) {
+		if from >= to || overlaps(from, to) {
+			return
+		}
+		offsets = append(offsets, [2]int{from, to})
+		link.Text = text[from:to]
+		link.Range = Range{Start: pos(from), End: pos(to - 1)}
+		links = append(links, link)
+	}
+
+	for _, loc := range linkURLPattern.FindAllStringIndex(text, -1) {
+		to := loc[0] + len(trimURL(text[loc[0]:loc[1]]))
+		add(loc[0], to, Link{Kind: LinkURL, URL: text[loc[0]:to]})
+	}
+	for _, m := range linkFilePattern.FindAllStringSubmatchIndex(text, -1) {
+		file := text[m[0] : m[2]-1]
+		line, _ := strconv.Atoi(text[m[2]:m[3]])
+		var col int
+		if m[4] >= 0 {
+			col, _ = strconv.Atoi(text[m[4]:m[5]])
+		}
+		add(m[0], m[1], Link{
+			Kind: LinkFile,
+			URL:  v.fileURL(file),
+			Path: file,
+			Line: line,
+			Col:  col,
+		})
+	}
+	for _, loc := range linkHashPattern.FindAllStringIndex(text, -1) {
+		hash := text[loc[0]:loc[1]]
+		if !strings.ContainsAny(hash, "abcdef") || !strings.ContainsAny(hash, "0123456789") {
+			// just a number or a word
+			continue
+		}
+		add(loc[0], loc[1], Link{Kind: LinkHash})
+	}
+
+	slices.SortFunc(links, func(a, b Link) int {
+		return cmp.Or(
+			cmp.Compare(a.Range.Start.Line, b.Range.Start.Line),
+			cmp.Compare(a.Range.Start.Col, b.Range.Start.Col),
+		)
+	})
+	return links
+}
+
+// trimURL removes trailing punctuation which is more likely to belong to the
+// surrounding text than to a URL.
+func trimURL(u string) string {
+	for len(u) > 0 {
+		switch last := u[len(u)-1]; last {
+		case '.', ',', ';', ':', '!', '?':
+		case ')', ']', '}':
+			open := map[byte]byte{')': '(', ']': '[', '}': '{'}[last]
+			if strings.Count(u, string(open)) >= strings.Count(u, string(last)) {
+				return u
+			}
+		default:
+			return u
+		}
+		u = u[:len(u)-1]
+	}
+	return u
+}
+
+// fileURL returns a file URL for a path, resolving relative paths against the
+// working directory, or "" if it can't be resolved.
+func (v *Terminal) fileURL(file string) string {
+	switch {
+	case path.IsAbs(file):
+	case v.Cwd.Path != "" && !strings.HasPrefix(file, "~"):
+		file = path.Join(v.Cwd.Path, file)
+	default:
+		return ""
+	}
+	return WorkingDirectory{Host: v.Cwd.Host, Path: file}.URL()
+}
+
+// linkSpan is the part of a link with a URL on a row.
+type linkSpan struct {
+	col, end int
+	url      string
+}
+
+// linkCache caches the link spans detected on each row of the screen, so
+// that rendering only detects links again on lines which have changed.
+type linkCache struct {
+	// screen is the screen the rows were detected on.
+	screen *Screen
+
+	// cwd is the working directory relative paths were resolved against.
+	cwd WorkingDirectory
+
+	// rows are the spans detected on each row.
+	rows []linkRow
+}
+
+// linkRow is a row of a line whose links have been detected.
+type linkRow struct {
+	line *linkLine
+
+	// index is the index of the row within the line.
+	index int
+}
+
+// linkLine caches the link spans detected on the rows of a soft-wrapped line,
+// as of the values of their Changes counters.
+type linkLine struct {
+	changes []uint64
+	spans   [][]linkSpan
+}
+
+// rowLinks returns the parts of links with URLs on a row of the screen, if
+// links are rendered, detecting them again only if the row's line has changed.
+func (v *Terminal) rowLinks(row int) []linkSpan {
+	if !v.RenderLinks || row < 0 || row >= len(v.Content) {
+		return nil
+	}
+	c := v.links
+	if c == nil || c.screen != v.Screen || c.cwd != v.Cwd || len(c.rows) != len(v.Content) {
+		c = &linkCache{
+			screen: v.Screen,
+			cwd:    v.Cwd,
+			rows:   make([]linkRow, len(v.Content)),
+		}
+		v.links = c
+	}
+	start, end := v.wrappedRows(row)
+	if r := c.rows[row]; r.line != nil && r.index == row-start && r.line.current(v.Changes[start:end+1]) {
+		return r.line.spans[r.index]
+	}
+
+	l := &linkLine{
+		changes: slices.Clone(v.Changes[start : end+1]),
+		spans:   make([][]linkSpan, end-start+1),
+	}
+	links := v.detectLinks(start, end)
+	for r := start; r <= end; r++ {
+		line := v.position(r, 0).Line
+		for _, link := range links {
+			if link.URL == "" {
+				continue
+			}
+			if from, to, ok := link.Range.cols(line); ok {
+				l.spans[r-start] = append(l.spans[r-start], linkSpan{col: from, end: min(to, len(v.Content[r])), url: link.URL})
+			}
+		}
+		c.rows[r] = linkRow{line: l, index: r - start}
+	}
+	return l.spans[row-start]
+}
+
+// current reports whether the rows of the line haven't changed since their
+// links were detected.
+func (l *linkLine) current(changes []uint64) bool {
+	return slices.Equal(l.changes, changes)
+}
+
+// shiftLinks follows the rows from start through end moving by delta rows, so
+// that the links detected on them don't need to be detected again.
+func (v *Terminal) shiftLinks(start, end, delta int) {
+	c := v.links
+	if c == nil || c.screen != v.Screen || len(c.rows) == 0 {
+		return
+	}
+	end = min(end, len(c.rows)-1)
+	rows := slices.Clone(c.rows)
+	for row := start; row <= end; row++ {
+		if from := row - delta; from >= start && from <= end {
+			c.rows[row] = rows[from]
+		} else {
+			c.rows[row] = linkRow{}
+		}
+	}
+}
+
+// linkAt returns the URL of the link span covering col, if any.
+func linkAt(spans []linkSpan, col int) string {
+	for _, span := range spans {
+		if col >= span.col && col < span.end {
+			return span.url
+		}
+	}
+	return ""
+}
+
+// hyperlinkSeq returns the OSC 8 sequence starting a hyperlink to url, or
+// ending one if url is empty.
+func hyperlinkSeq(url string) string {
+	return "\x1b]8;;" + url + "\x1b\\"
+}
@@ -0,0 +1,127 @@
+package midterm_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vito/midterm"
+)
+
+func TestLinks(t *testing.T) {
+	t.Run("detects URLs, file references and hashes", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 80)
+		mustFprintf(t, vt, "see https://en.wikipedia.org/wiki/Go_(language). or http://x.io/a,\r\n")
+		mustFprintf(t, vt, "at main.go:12:5 and ./pkg/x_test.go:3 in abc1234 not 12345678 or deadbeef")
+
+		type found struct {
+			Kind      midterm.LinkKind
+			Text      string
+			Path      string
+			Line, Col int
+		}
+		var got []found
+		for _, l := range vt.Links() {
+			got = append(got, found{l.Kind, l.Text, l.Path, l.Line, l.Col})
+		}
+		require.Equal(t, []found{
+			{Kind: midterm.LinkURL, Text: "https://en.wikipedia.org/wiki/Go_(language)"},
+			{Kind: midterm.LinkURL, Text: "http://x.io/a"},
+			{Kind: midterm.LinkFile, Text: "main.go:12:5", Path: "main.go", Line: 12, Col: 5},
+			{Kind: midterm.LinkFile, Text: "./pkg/x_test.go:3", Path: "./pkg/x_test.go", Line: 3},
+			{Kind: midterm.LinkHash, Text: "abc1234"},
+		}, got)
+
+		link, ok := vt.LinkAt(vt.Position(1, 5))
+		require.True(t, ok)
+		require.Equal(t, "main.go:12:5", link.Text)
+		require.Equal(t, midterm.Range{Start: vt.Position(1, 3), End: vt.Position(1, 14)}, link.Range)
+		_, ok = vt.LinkAt(vt.Position(1, 15))
+		require.False(t, ok)
+	})
+
+	t.Run("detects links across soft wraps", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 10)
+		mustFprintf(t, vt, "> https://example.com/x y")
+
+		link, ok := vt.LinkAt(vt.Position(2, 1))
+		require.True(t, ok)
+		require.Equal(t, "https://example.com/x", link.URL)
+		require.Equal(t, midterm.Range{Start: vt.Position(0, 2), End: vt.Position(2, 2)}, link.Range)
+	})
+
+	t.Run("resolves files against the working directory", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 40)
+		mustFprintf(t, vt, "a.go:1 /etc/x.conf:2 ~/b.go:3")
+		links := vt.Links()
+		require.Len(t, links, 3)
+		require.Empty(t, links[0].URL)
+		require.Equal(t, "file:///etc/x.conf", links[1].URL)
+		require.Empty(t, links[2].URL)
+
+		mustFprintf(t, vt, "\x1b]7;file://box/src/app\x07")
+		require.Equal(t, "file://box/src/app/a.go", vt.Links()[0].URL)
+	})
+
+	t.Run("renders links as hyperlinks", func(t *testing.T) {
+		vt := midterm.NewTerminal(2, 20)
+		mustFprintf(t, vt, "go https://x.io now")
+
+		var buf strings.Builder
+		require.NoError(t, vt.RenderLine(&buf, 0))
+		require.NotContains(t, buf.String(), "\x1b]8;")
+
+		vt.RenderLinks = true
+		buf.Reset()
+		require.NoError(t, vt.RenderLine(&buf, 0))
+		require.Equal(t, "go \x1b]8;;https://x.io\x1b\\https://x.io\x1b]8;;\x1b\\ now \x1b[0m", buf.String())
+
+		require.Regexp(t, `<a href="https://x.io"><span style="[^"]*">https://x.io</span></a>`, vt.HTML())
+	})
+
+	t.Run("detects links on auto-resizing terminals", func(t *testing.T) {
+		vt := midterm.NewAutoResizingTerminal()
+		mustFprintf(t, vt, "short\r\nsee https://example.com/x")
+
+		links := vt.Links()
+		require.Len(t, links, 1)
+		require.Equal(t, "https://example.com/x", links[0].URL)
+		require.Equal(t, midterm.Range{Start: vt.Position(1, 4), End: vt.Position(1, 24)}, links[0].Range)
+
+		link, ok := vt.LinkAt(vt.Position(1, 10))
+		require.True(t, ok)
+		require.Equal(t, links[0], link)
+
+		vt.RenderLinks = true
+		var buf strings.Builder
+		require.NoError(t, vt.RenderLine(&buf, 1))
+		require.Contains(t, buf.String(), "\x1b]8;;https://example.com/x\x1b\\")
+	})
+
+	t.Run("renders links again as the screen changes", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 20)
+		vt.RenderLinks = true
+		mustFprintf(t, vt, "a.go:1\r\nhttps://x.io\r\n")
+
+		render := func(row int) string {
+			var buf strings.Builder
+			require.NoError(t, vt.RenderLine(&buf, row))
+			return buf.String()
+		}
+		require.NotContains(t, render(0), "\x1b]8;")
+		require.Contains(t, render(1), "\x1b]8;;https://x.io\x1b\\")
+
+		// relative paths resolve once the working directory is known
+		mustFprintf(t, vt, "\x1b]7;file://box/src\x07")
+		require.Contains(t, render(0), "\x1b]8;;file://box/src/a.go\x1b\\")
+
+		// the links follow their rows as the screen scrolls
+		mustFprintf(t, vt, "\r\n")
+		require.Contains(t, render(0), "\x1b]8;;https://x.io\x1b\\")
+		require.NotContains(t, render(1), "\x1b]8;")
+
+		// and are detected again when a row changes
+		mustFprintf(t, vt, "\x1b[1;13Hy")
+		require.Contains(t, render(0), "\x1b]8;;https://x.ioy\x1b\\")
+	})
+}
@@ -39,6 +39,8 @@ func (vt *Terminal) MarshalBinary() (data []byte, err error) {
 	}
 	buffer.Write(bytez)
 
+	buffer.Write(vt.Modes.marshalBinary())
+
 	if vt.Title != "" {
 		_, err = fmt.Fprintf(&buffer, termenv.OSC+termenv.SetWindowTitleSeq, vt.Title)
 		if err != nil {
@@ -46,6 +48,13 @@ func (vt *Terminal) MarshalBinary() (data []byte, err error) {
 		}
 	}
 
+	if vt.Cwd != (WorkingDirectory{}) {
+		_, err = fmt.Fprintf(&buffer, termenv.OSC+"7;%s\x07", vt.Cwd.URL())
+		if err != nil {
+			return
+		}
+	}
+
 	if vt.wrap { // Hack to force wrap flag into correct state
 		row := vt.Cursor.Y
 		col := vt.Cursor.X
@@ -84,7 +93,9 @@ func (vt *Terminal) MarshalBinary() (data []byte, err error) {
 func (s *Screen) marshalBinary() (data []byte, err error) {
 	var buffer bytes.Buffer
 	for i := 0; i <= s.MaxY; i++ {
-		if i > 0 {
+		// soft-wrapped rows run straight into the next one, so that they wrap
+		// again when replayed
+		if i > 0 && !s.Info[i-1].Wrapped {
 			_, _ = io.WriteString(&buffer, "\r\n")
 		}
 		var line []byte
@@ -0,0 +1,143 @@
+package midterm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Notification is a desktop notification requested by an application, via
+// OSC 9 ; body or OSC 777 ; notify ; title ; body.
+type Notification struct {
+	// Title is the title of the notification. OSC 9 notifications have none.
+	Title string
+
+	// Body is the message of the notification.
+	Body string
+}
+
+type OnNotifyFunc func(n Notification)
+
+// OnNotify sets a hook called for each desktop notification. The hook is
+// called once the Write which sent it has finished processing input, so it may
+// use the terminal.
+func (v *Terminal) OnNotify(f OnNotifyFunc) {
+	v.mut.Lock()
+	v.onNotify = f
+	v.mut.Unlock()
+}
+
+// ProgressState is the state of a progress indicator.
+type ProgressState int
+
+const (
+	// ProgressNone means there is no progress to display.
+	ProgressNone ProgressState = iota
+	// ProgressNormal means progress is being made, as given by the percent.
+	ProgressNormal
+	// ProgressError means the operation has failed.
+	ProgressError
+	// ProgressIndeterminate means the operation is ongoing, but the amount
+	// of progress is unknown.
+	ProgressIndeterminate
+	// ProgressPaused means the operation is paused.
+	ProgressPaused
+)
+
+func (s ProgressState) String() string {
+	switch s {
+	case ProgressNone:
+		return "none"
+	case ProgressNormal:
+		return "normal"
+	case ProgressError:
+		return "error"
+	case ProgressIndeterminate:
+		return "indeterminate"
+	case ProgressPaused:
+		return "paused"
+	default:
+		return fmt.Sprintf("ProgressState(%d)", int(s))
+	}
+}
+
+// Progress is a progress report sent via ConEmu's OSC 9 ; 4 ; state ; percent.
+type Progress struct {
+	State ProgressState
+
+	// Percent is the percentage of progress made, from 0 to 100.
+	Percent int
+}
+
+type OnProgressFunc func(p Progress)
+
+// OnProgress sets a hook called for each progress report. The hook is called
+// once the Write which sent it has finished processing input, so it may use
+// the terminal.
+func (v *Terminal) OnProgress(f OnProgressFunc) {
+	v.mut.Lock()
+	v.onProgress = f
+	v.mut.Unlock()
+}
+
+// osc9 handles OSC 9, which is either a notification or one of ConEmu's
+// numbered commands.
+func (v *Terminal) osc9(params []string) {
+	if len(params) == 0 {
+		return
+	}
+	// a number followed by arguments is a ConEmu command rather than a message
+	if n, err := strconv.Atoi(params[0]); err == nil && (len(params) > 1 || n == 4) {
+		if n == 4 {
+			v.progress(params[1:])
+		} else {
+			dbg.Printf("ConEmu: %v (ignored)\n", params)
+		}
+		return
+	}
+	v.notify(Notification{Body: strings.Join(params, ";")})
+}
+
+// osc777 handles OSC 777, of which only notify is supported.
+func (v *Terminal) osc777(params []string) {
+	if len(params) < 2 || params[0] != "notify" {
+		dbg.Printf("OSC 777: %v (ignored)\n", params)
+		return
+	}
+	v.notify(Notification{
+		Title: params[1],
+		Body:  strings.Join(params[2:], ";"),
+	})
+}
+
+func (v *Terminal) notify(n Notification) {
+	dbg.Printf("Notify: title=%q body=%q\n", n.Title, n.Body)
+	if f := v.onNotify; f != nil {
+		v.later(func() { f(n) })
+	}
+}
+
+// progress handles the parameters of OSC 9 ; 4.
+func (v *Terminal) progress(params []string) {
+	var p Progress
+	if len(params) > 0 && params[0] != "" {
+		state, err := strconv.Atoi(params[0])
+		if err != nil || state < 0 || state > int(ProgressPaused) {
+			dbg.Printf("Progress: invalid state %q\n", params[0])
+			return
+		}
+		p.State = ProgressState(state)
+	}
+	if len(params) > 1 && params[1] != "" {
+		percent, err := strconv.Atoi(params[1])
+		if err != nil {
+			dbg.Printf("Progress: invalid percent %q\n", params[1])
+			return
+		}
+		p.Percent = min(max(percent, 0), 100)
+	}
+	dbg.Printf("Progress: state=%s percent=%d\n", p.State, p.Percent)
+	if f := v.onProgress; f != nil {
+		v.later(func() { f(p) })
+	}
+}
@@ -0,0 +1,54 @@
+package midterm_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vito/midterm"
+)
+
+func TestNotifications(t *testing.T) {
+	vt := midterm.NewTerminal(5, 20)
+	var notifications []midterm.Notification
+	vt.OnNotify(func(n midterm.Notification) {
+		notifications = append(notifications, n)
+	})
+
+	mustFprintf(t, vt, "\x1b]9;build finished; 3 warnings\x07")
+	mustFprintf(t, vt, "\x1b]777;notify;CI;tests passed; ship it\x1b\\")
+	mustFprintf(t, vt, "\x1b]777;preexec\x07")
+	mustFprintf(t, vt, "\x1b]9;42\x07")
+
+	require.Equal(t, []midterm.Notification{
+		{Body: "build finished; 3 warnings"},
+		{Title: "CI", Body: "tests passed; ship it"},
+		{Body: "42"},
+	}, notifications)
+}
+
+func TestProgress(t *testing.T) {
+	vt := midterm.NewTerminal(5, 20)
+	var reports []midterm.Progress
+	vt.OnProgress(func(p midterm.Progress) {
+		reports = append(reports, p)
+	})
+	var notifications int
+	vt.OnNotify(func(midterm.Notification) {
+		notifications++
+	})
+
+	mustFprintf(t, vt, "\x1b]9;4;1;25\x07")
+	mustFprintf(t, vt, "\x1b]9;4;3\x07")
+	mustFprintf(t, vt, "\x1b]9;4;2;150\x07")
+	mustFprintf(t, vt, "\x1b]9;4;9;10\x07") // invalid state
+	mustFprintf(t, vt, "\x1b]9;4;0;0\x07")
+	mustFprintf(t, vt, "\x1b]9;1;100\x07") // ConEmu sleep
+
+	require.Equal(t, []midterm.Progress{
+		{State: midterm.ProgressNormal, Percent: 25},
+		{State: midterm.ProgressIndeterminate},
+		{State: midterm.ProgressError, Percent: 100},
+		{State: midterm.ProgressNone},
+	}, reports)
+	require.Zero(t, notifications)
+}
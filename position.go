@@ -0,0 +1,94 @@
+package midterm
+
+import "strings"
+
+// Position is a cell in the terminal's history. Line counts every line that
+// has been displayed on the main screen, including those since scrolled off
+// the top, so that a position keeps referring to the same content as the
+// screen scrolls.
+type Position struct {
+	Line, Col int
+}
+
+// lineNumbers numbers the lines of the main screen. It is only allocated once
+// something is anchored to lines, e.g. a shell integration mark or a
+// selection, so line numbers count from that point on.
+type lineNumbers struct {
+	// scrolled counts the lines scrolled off the top of the main screen.
+	scrolled int
+}
+
+// trackLines starts numbering lines, if not already.
+func (v *Terminal) trackLines() {
+	if v.lines == nil {
+		v.lines = &lineNumbers{}
+	}
+}
+
+// scrolled returns the number of lines scrolled off the top of the main screen
+// since lines started being numbered.
+func (v *Terminal) scrolled() int {
+	if v.lines == nil {
+		return 0
+	}
+	return v.lines.scrolled
+}
+
+// Position returns the position of a cell of the screen, which keeps referring
+// to the same content as the screen scrolls.
+func (v *Terminal) Position(row, col int) Position {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	v.trackLines()
+	return v.position(row, col)
+}
+
+// position returns the position of a cell of the screen.
+func (v *Terminal) position(row, col int) Position {
+	return Position{Line: v.scrolled() + row, Col: col}
+}
+
+// mainScreen returns the main screen, which may be in the background while
+// the alternate screen is active.
+func (v *Terminal) mainScreen() *Screen {
+	if v.IsAlt {
+		return v.Alt
+	}
+	return v.Screen
+}
+
+// lineAt returns the content and metadata of a line of the screen s, which is
+// read from the Scrollback if it has scrolled off the top of the main screen.
+func (v *Terminal) lineAt(s *Screen, line int) ([]rune, LineInfo, bool) {
+	row := line - v.scrolled()
+	switch {
+	case row >= 0 && row < len(s.Content):
+		return s.Content[row], s.Info[row], true
+	case row < 0 && s == v.mainScreen() && v.Scrollback != nil && v.Scrollback.Len()+row >= 0:
+		l := v.Scrollback.Line(v.Scrollback.Len() + row)
+		return l.Content, l.Info, true
+	default:
+		return nil, LineInfo{}, false
+	}
+}
+
+// textBetween returns the text of the main screen and its scrollback from start
+// up to end, like Text, with trailing blank lines trimmed.
+func (v *Terminal) textBetween(start, end Position) string {
+	if end.Line < start.Line || end.Line == start.Line && end.Col <= start.Col {
+		return ""
+	}
+	// ranges include their end
+	end.Col--
+	return strings.TrimRight(v.rangeText(v.mainScreen(), Range{Start: start, End: end}), "\n")
+}
+
+// shiftPositions follows the rows from start through end moving by delta rows.
+func (v *Terminal) shiftPositions(start, end, delta int, positions []*Position) {
+	scrolled := v.scrolled()
+	for _, pos := range positions {
+		if row := pos.Line - scrolled; row >= start && row <= end {
+			pos.Line += delta
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package midterm
+
+import "maps"
+
+// logicalLine is a line of text as it was printed, spanning all the rows it
+// was soft-wrapped across.
+type logicalLine struct {
+	Line
+
+	// row and rows are the first row of the line and the number of rows it
+	// spans, before reflowing.
+	row, rows int
+
+	// keep is the number of cells which must be kept even if blank, because
+	// the cursor is positioned there.
+	keep int
+
+	// newRow is the first row of the line, after reflowing.
+	newRow int
+}
+
+// reflow changes the width of the main screen s, rejoining soft-wrapped rows
+// into logical lines and wrapping them again at the new width. The cursor,
+// images, shell integration marks and the selection follow the text they were
+// on, while search results are cleared. If the rewrapped text no longer fits,
+// lines are scrolled off the top as if into scrollback.
+func (v *Terminal) reflow(s *Screen, w int) {
+	oldWidth := s.Width
+	// the main screen may be in the background while the alt screen is active
+	active := s == v.Screen
+
+	last := min(max(s.MaxY, s.Cursor.Y, s.SavedCursor.Y), len(s.Content)-1)
+	if last < 0 {
+		s.resizeX(w)
+		return
+	}
+
+	// join the rows into logical lines
+	var lines []*logicalLine
+	lineOf := make([]*logicalLine, last+1)
+	for row := 0; row <= last; row++ {
+		if row == 0 || !s.Info[row-1].Wrapped {
+			lines = append(lines, &logicalLine{row: row})
+		}
+		line := lines[len(lines)-1]
+		snapshot := s.line(row)
+		line.Content = append(line.Content, snapshot.Content...)
+		line.Format = append(line.Format, snapshot.Format...)
+		line.rows++
+		lineOf[row] = line
+	}
+
+	// offset returns the position of a cell within its logical line
+	offset := func(row, col int) (*logicalLine, int) {
+		line := lineOf[min(row, last)]
+		return line, (row-line.row)*oldWidth + col
+	}
+
+	cursorLine, cursorOffset := offset(s.Cursor.Y, s.Cursor.X)
+	wrapping := active && v.wrap
+	if wrapping {
+		// the cursor belongs after the last cell
+		cursorOffset++
+		cursorLine.keep = max(cursorLine.keep, cursorOffset)
+	} else {
+		cursorLine.keep = max(cursorLine.keep, cursorOffset+1)
+	}
+	savedLine, savedOffset := offset(s.SavedCursor.Y, s.SavedCursor.X)
+	savedLine.keep = max(savedLine.keep, savedOffset+1)
+
+	// trim trailing blanks, which would otherwise be wrapped onto rows of
+	// their own when narrowing
+	for _, line := range lines {
+		n := len(line.Content)
+		for n > line.keep && line.Content[n-1] == ' ' &&
+			(line.Format[n-1] == EmptyFormat || line.Format[n-1] == Reset) {
+			n--
+		}
+		line.Content = line.Content[:n]
+		line.Format = line.Format[:n]
+	}
+
+	// wrap the lines at the new width
+	var rows []Line
+	maxX := min(s.MaxX, w-1)
+	for _, line := range lines {
+		line.newRow = len(rows)
+		n := max((len(line.Content)+w-1)/w, (line.keep+w-1)/w, 1)
+		for i := 0; i < n; i++ {
+			start := min(i*w, len(line.Content))
+			end := min(start+w, len(line.Content))
+			// keep the metadata of the row the text came from
+			info := s.Info[line.row+min(start/oldWidth, line.rows-1)]
+			info.Tags = maps.Clone(info.Tags)
+			info.Wrapped = i < n-1
+			rows = append(rows, Line{
+				Content: line.Content[start:end],
+				Format:  line.Format[start:end],
+				Info:    info,
+			})
+		}
+		if n > 1 {
+			maxX = w - 1
+		}
+	}
+
+	// newPos returns the new position of a cell
+	newPos := func(row, col int) (int, int) {
+		switch {
+		case row < 0:
+			return row, col
+		case row > last:
+			return row - (last + 1) + len(rows), col
+		}
+		line, off := offset(row, min(col, oldWidth-1))
+		return line.newRow + off/w, off % w
+	}
+
+	cursorY, cursorX := cursorLine.newRow+cursorOffset/w, cursorOffset%w
+	if wrapping {
+		if cursorX == 0 && cursorOffset > 0 {
+			// keep waiting to wrap at the end of the row
+			cursorY, cursorX = cursorY-1, w-1
+		} else {
+			wrapping = false
+		}
+	}
+	savedY, savedX := newPos(s.SavedCursor.Y, s.SavedCursor.X)
+
+	// scroll lines off the top if they no longer fit, but never the cursor
+	var scrolled int
+	if len(rows) > s.Height {
+		scrolled = min(len(rows)-s.Height, cursorY)
+	}
+	if scrolled > 0 && (v.onScrollback != nil || v.Scrollback != nil) {
+		for _, line := range rows[:scrolled] {
+			v.pushScrollback(Line{
+				Content: append([]rune(nil), line.Content...),
+				Format:  append([]Format(nil), line.Format...),
+				Info:    line.Info,
+			})
+		}
+	}
+
+	// lay out the new screen
+	content := make([][]rune, s.Height)
+	canvas := &Canvas{Width: w, Rows: make([]*Region, s.Height)}
+	info := make([]LineInfo, s.Height)
+	for y := range s.Height {
+		content[y] = make([]rune, w)
+		var formats []Format
+		if row := y + scrolled; row < len(rows) {
+			copy(content[y], rows[row].Content)
+			formats = rows[row].Format
+			info[y] = rows[row].Info
+		}
+		for x := len(formats); x < w; x++ {
+			content[y][x] = ' '
+		}
+		canvas.Rows[y] = regionsOf(formats, w)
+	}
+
+	// move everything anchored to rows along with them
+	for _, p := range s.Placements {
+		p.Row, p.Col = newPos(p.Row, p.Col)
+		p.Row -= scrolled
+	}
+	s.Placements = deleteOffscreen(s.Placements, s.Height)
+	var positions []*Position
+	if v.shell != nil {
+		positions = append(positions, v.shell.positions()...)
+	}
+	if v.selection != nil && active {
+		positions = append(positions, v.selection.positions()...)
+	}
+	for _, pos := range positions {
+		row, col := newPos(pos.Line-v.scrolled(), pos.Col)
+		pos.Line, pos.Col = v.scrolled()+row, col
+	}
+	if v.lines != nil {
+		v.lines.scrolled += scrolled
+	}
+	v.pruneBlocks()
+	// every row has changed, and may have been pushed into scrollback unseen
+	v.searchCache = nil
+	if active {
+		// results are of the old rows, so they'd highlight the wrong cells
+		v.SearchMatches = nil
+		v.SearchHighlights = nil
+	}
+
+	maxY := -1
+	if s.MaxY >= 0 {
+		maxLine := lineOf[min(s.MaxY, last)]
+		maxY = maxLine.newRow + max(len(maxLine.Content)-1, 0)/w
+	}
+
+	s.Content = content
+	s.Format = canvas
+	s.Info = info
+	for y := range s.Changes {
+		s.Changes[y]++
+	}
+	s.damagedAll()
+	s.Width = w
+	s.Cursor.Y, s.Cursor.X = min(cursorY-scrolled, s.Height-1), cursorX
+	s.SavedCursor.Y, s.SavedCursor.X = min(max(savedY-scrolled, 0), s.Height-1), min(savedX, w-1)
+	s.MaxY = min(max(maxY-scrolled, -1), s.Height-1)
+	s.MaxX = maxX
+	if active {
+		v.wrap = wrapping
+	}
+}
+
+// deleteOffscreen removes placements which lie entirely outside of a screen of
+// the given height.
+func deleteOffscreen(placements []*ImagePlacement, height int) []*ImagePlacement {
+	kept := placements[:0]
+	for _, p := range placements {
+		if p.Row+p.Rows > 0 && p.Row < height {
+			kept = append(kept, p)
+		}
+	}
+	clear(placements[len(kept):])
+	return kept
+}
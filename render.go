@@ -43,6 +43,7 @@ func (vt *Terminal) RenderLineFgBg(w io.Writer, row int, fg, bg termenv.Color) e
 type Line struct {
 	Content []rune
 	Format  []Format
+	Info    LineInfo
 }
 
 func (line Line) Display() string {
@@ -73,7 +74,12 @@ func (vt *Terminal) renderLine(w io.Writer, row int, fg, bg termenv.Color) error
 
 	var pos int
 	lastFormat := EmptyFormat
+	flash := vt.flashing()
 	format := func(f Format) error {
+		if flash {
+			// the visual bell inverts the whole screen
+			f.SetReverse(!f.IsReverse())
+		}
 		if lastFormat != f {
 			// RenderFgBg emits only "on" sequences; if f drops an attribute or
 			// color the previous format set, reset first so it doesn't bleed in.
@@ -96,11 +102,11 @@ func (vt *Terminal) renderLine(w io.Writer, row int, fg, bg termenv.Color) error
 		}
 	}
 
-	// Pre-fetch search highlights for this row (if any).
-	var searchHL []SearchHighlight
-	if vt.SearchHighlights != nil {
-		searchHL = vt.SearchHighlights[row]
-	}
+	// Pre-fetch the highlights and links for this row (if any).
+	override := vt.rowOverrides(row)
+	links := vt.rowLinks(row)
+	// link is the URL of the hyperlink being written, if any
+	var link string
 
 	for region := range vt.Format.Regions(row) {
 		line := vt.Content[row]
@@ -112,7 +118,33 @@ func (vt *Terminal) renderLine(w io.Writer, row int, fg, bg termenv.Color) error
 			(vt.CursorBlinkEpoch == nil ||
 				int(time.Since(*vt.CursorBlinkEpoch).Seconds())%2 == 0)
 
-		if showCursor {
+		if override != nil || len(links) > 0 {
+			// Render character-by-character, overriding format for highlighted
+			// cols and marking links as hyperlinks.
+			for col := pos; col < pos+region.Size; col++ {
+				f := region.F
+				if override != nil {
+					if hlF, ok := override(col); ok {
+						f = hlF
+					}
+				}
+				if showCursor && col == vt.Cursor.X {
+					f.SetReverse(!f.IsReverse())
+				}
+				if url := linkAt(links, col); url != link {
+					if err := write(hyperlinkSeq(url)); err != nil {
+						return err
+					}
+					link = url
+				}
+				if err := format(f); err != nil {
+					return err
+				}
+				if err := write(string(line[col])); err != nil {
+					return err
+				}
+			}
+		} else if showCursor {
 			before := string(line[pos:vt.Cursor.X])
 			cursor := string(line[vt.Cursor.X])
 			after := string(line[vt.Cursor.X+1 : pos+region.Size])
@@ -143,20 +175,6 @@ func (vt *Terminal) renderLine(w io.Writer, row int, fg, bg termenv.Color) error
 					return err
 				}
 			}
-		} else if len(searchHL) > 0 {
-			// Render character-by-character, overriding format for highlighted cols.
-			for col := pos; col < pos+region.Size; col++ {
-				f := region.F
-				if hlF, ok := vt.searchHighlightAt(searchHL, col); ok {
-					f = hlF
-				}
-				if err := format(f); err != nil {
-					return err
-				}
-				if err := write(string(line[col])); err != nil {
-					return err
-				}
-			}
 		} else {
 			if err := format(region.F); err != nil {
 				return err
@@ -170,9 +188,57 @@ func (vt *Terminal) renderLine(w io.Writer, row int, fg, bg termenv.Color) error
 		pos += region.Size
 	}
 
+	if link != "" {
+		if err := write(hyperlinkSeq("")); err != nil {
+			return err
+		}
+	}
 	return write(resetSeq)
 }
 
+// renderCells writes a line to w, padded with blanks to the given width.
+func renderCells(w io.Writer, line Line, width int, fg, bg termenv.Color) error {
+	lastFormat := EmptyFormat
+	if fg != nil || bg != nil {
+		lastFormat = Format{Fg: fg, Bg: bg}
+		if _, err := io.WriteString(w, lastFormat.RenderFgBg(fg, bg)); err != nil {
+			return err
+		}
+	}
+	for col, r := range line.Content {
+		var f Format
+		if col < len(line.Format) {
+			f = line.Format[col]
+		}
+		if f != lastFormat {
+			if leaksInto(lastFormat, f, fg, bg) {
+				if _, err := io.WriteString(w, resetSeq); err != nil {
+					return err
+				}
+			}
+			if _, err := io.WriteString(w, f.RenderFgBg(fg, bg)); err != nil {
+				return err
+			}
+			lastFormat = f
+		}
+		if _, err := io.WriteString(w, string(r)); err != nil {
+			return err
+		}
+	}
+	if pad := width - len(line.Content); pad > 0 {
+		if lastFormat != EmptyFormat {
+			if _, err := io.WriteString(w, EmptyFormat.RenderFgBg(fg, bg)); err != nil {
+				return err
+			}
+		}
+		if _, err := io.WriteString(w, strings.Repeat(" ", pad)); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, resetSeq)
+	return err
+}
+
 // leaksInto reports whether emitting f right after prev needs an explicit
 // reset first: RenderFgBg emits only "on" sequences, so any attribute or color
 // prev set that f drops would otherwise bleed through. fg and bg are
@@ -199,6 +265,30 @@ func orColor(c, fallback termenv.Color) termenv.Color {
 	return fallback
 }
 
+// rowOverrides returns a function giving the Format override for a column of
+// a row, from the selection, search highlights and highlight rules in order of
+// precedence, or nil if nothing on the row is highlighted.
+func (vt *Terminal) rowOverrides(row int) func(col int) (Format, bool) {
+	var searchHL []SearchHighlight
+	if vt.SearchHighlights != nil {
+		searchHL = vt.SearchHighlights[row]
+	}
+	selFrom, selTo, selected := vt.selectedCols(vt.position(row, 0).Line)
+	rules := vt.ruleSpans(row)
+	if len(searchHL) == 0 && !selected && len(rules) == 0 {
+		return nil
+	}
+	return func(col int) (Format, bool) {
+		if selected && col >= selFrom && col < selTo {
+			return vt.SelectionStyle, true
+		}
+		if f, ok := vt.searchHighlightAt(searchHL, col); ok {
+			return f, true
+		}
+		return ruleStyleAt(rules, col)
+	}
+}
+
 // searchHighlightAt checks if col falls within any search highlight range
 // and returns the appropriate format override.
 func (vt *Terminal) searchHighlightAt(highlights []SearchHighlight, col int) (Format, bool) {
@@ -213,6 +303,24 @@ func (vt *Terminal) searchHighlightAt(highlights []SearchHighlight, col int) (Fo
 	return Format{}, false
 }
 
+// searchHighlightLine overrides the formats of the highlighted cells of a row,
+// which may be in the scrollback.
+func (vt *Terminal) searchHighlightLine(row int, l Line) Line {
+	hls := vt.SearchHighlights[row]
+	if len(hls) == 0 {
+		return l
+	}
+	format := make([]Format, len(l.Content))
+	copy(format, l.Format)
+	for col := range format {
+		if f, ok := vt.searchHighlightAt(hls, col); ok {
+			format[col] = f
+		}
+	}
+	l.Format = format
+	return l
+}
+
 const resetSeq = termenv.CSI + termenv.ResetSeq + "m"
 
 func brighten(color termenv.Color) termenv.Color {
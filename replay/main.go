@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -10,8 +11,9 @@ import (
 	"os/exec"
 
 	tea "github.com/charmbracelet/bubbletea"
-	"github.com/creack/pty"
 	"github.com/vito/midterm"
+	"github.com/vito/midterm/session"
+	"github.com/vito/midterm/teaterm"
 	"golang.org/x/term"
 )
 
@@ -62,48 +64,44 @@ func record(out string, cmd []string) error {
 	}
 	defer func() { _ = term.Restore(int(os.Stdin.Fd()), oldState) }() // Best effort.
 
-	// Start the command with a pty.
-	ptmx, err := pty.StartWithSize(c, &pty.Winsize{Rows: rows, Cols: cols})
+	raw, err := os.Create(out)
 	if err != nil {
 		return err
 	}
-	// Make sure to close the pty at the end.
-	defer func() { _ = ptmx.Close() }() // Best effort.
+	defer func() { _ = raw.Close() }() // Best effort.
 
 	vt := midterm.NewTerminal(rows, cols)
 	vt.Raw = true
 	vt.CursorVisible = true
-	vt.ForwardResponses = ptmx
 	vt.ForwardRequests = os.Stdout
 
-	prog := tea.NewProgram(&vtModel{vt}, tea.WithInput(nil))
-
-	// Copy stdin to the pty and the pty to stdout.
-	// NOTE: The goroutine will keep reading until the next keystroke before returning.
-	go func() {
-		_, _ = io.Copy(ptmx, os.Stdin)
-	}()
+	// input is copied to the pty directly below, rather than through the model
+	prog := tea.NewProgram(teaterm.New(vt, nil), tea.WithInput(nil))
 
-	raw, err := os.Create(out)
+	// Start the command with a pty, recording its output.
+	s, err := session.Start(context.Background(), vt, c, session.Options{
+		Output: func(p []byte) {
+			if _, err := raw.Write(p); err != nil {
+				prog.Quit()
+				return
+			}
+			prog.Send(teaterm.OutputMsg(p))
+		},
+	})
 	if err != nil {
 		return err
 	}
-	defer func() { _ = raw.Close() }() // Best effort.
+	// Make sure to close the pty at the end.
+	defer func() { _ = s.Close() }() // Best effort.
 
+	// Copy stdin to the pty.
+	// NOTE: The goroutine will keep reading until the next keystroke before returning.
 	go func() {
-		for {
-			buf := make([]byte, 32*1024)
-			n, err := ptmx.Read(buf)
-			if err != nil {
-				break
-			}
-			if _, err := raw.Write(buf[:n]); err != nil {
-				prog.Send(exitMsg(err))
-				break
-			}
-			prog.Send(ptyMsg(buf[:n]))
-		}
+		_, _ = io.Copy(s, os.Stdin)
+	}()
 
+	go func() {
+		<-s.Done()
 		prog.Quit()
 	}()
 
@@ -191,38 +189,6 @@ func replayLogs(out string) error {
 	return nil
 }
 
-type vtModel struct {
-	vt *midterm.Terminal
-}
-
-func (m vtModel) Init() tea.Cmd {
-	return nil
-}
-
-type exitMsg error
-
-type ptyMsg []byte
-
-func (m vtModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
-	switch x := msg.(type) {
-	case exitMsg:
-		return m, tea.Quit
-	case ptyMsg:
-		if _, err := m.vt.Write(x); err != nil {
-			return m, func() tea.Msg { return exitMsg(err) }
-		}
-	}
-	return m, nil
-}
-
-func (m vtModel) View() string {
-	buf := new(bytes.Buffer)
-	if err := renderVt(buf, m.vt); err != nil {
-		return err.Error()
-	}
-	return buf.String()
-}
-
 func renderVt(w io.Writer, vt *midterm.Terminal) error {
 	for i := 0; i < vt.Height; i++ {
 		if i > 0 {
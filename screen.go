@@ -1,6 +1,8 @@
 package midterm
 
 import (
+	"maps"
+	"slices"
 	"time"
 )
 
@@ -22,6 +24,10 @@ type Screen struct {
 	// incremented.
 	Changes []uint64
 
+	// Info holds metadata about each row, which moves along with the row as
+	// the screen scrolls.
+	Info []LineInfo
+
 	// Cursor is the current state of the cursor.
 	Cursor Cursor
 
@@ -47,6 +53,14 @@ type Screen struct {
 	MaxY int
 	// MaxX is the maximum horizontal offset that a character has been printed.
 	MaxX int
+
+	// Placements are the images displayed on the screen, in the order that
+	// they were placed.
+	Placements []*ImagePlacement
+
+	// damage accumulates the cells changed since damage was last taken, if
+	// it is being tracked.
+	damage *damageTracker
 }
 
 func newScreen(h, w int) *Screen {
@@ -68,6 +82,7 @@ func (s *Screen) reset() {
 	s.Content = make([][]rune, s.Height)
 	s.Format = &Canvas{Width: s.Width}
 	s.Changes = make([]uint64, s.Height)
+	s.Info = make([]LineInfo, s.Height)
 	for row := 0; row < s.Height; row++ {
 		s.Content[row] = make([]rune, s.Width)
 		for col := 0; col < s.Width; col++ {
@@ -75,8 +90,10 @@ func (s *Screen) reset() {
 			s.Format.Paint(row, col, EmptyFormat)
 		}
 	}
+	s.Placements = nil
 	s.Cursor.X = 0
 	s.Cursor.Y = 0
+	s.damagedAll()
 }
 
 func (v *Screen) resize(h, w int) {
@@ -97,6 +114,10 @@ func (v *Screen) resizeY(h int) {
 	case h < v.Height:
 		v.Content = v.Content[:h]
 		v.Changes = v.Changes[:h]
+		v.Info = v.Info[:h]
+		v.Placements = slices.DeleteFunc(v.Placements, func(p *ImagePlacement) bool {
+			return p.Row >= h
+		})
 	}
 
 	v.Height = h
@@ -128,6 +149,22 @@ func (v *Screen) resizeX(w int) {
 	}
 }
 
+// line returns a copy of the content and format of a row.
+func (v *Screen) line(row int) Line {
+	content := append([]rune(nil), v.Content[row]...)
+	format := make([]Format, len(content))
+	col := 0
+	for r := range v.Format.Regions(row) {
+		for j := 0; j < r.Size && col < len(format); j++ {
+			format[col] = r.F
+			col++
+		}
+	}
+	info := v.Info[row]
+	info.Tags = maps.Clone(info.Tags)
+	return Line{Content: content, Format: format, Info: info}
+}
+
 func (v *Screen) clear(y, x int, format Format) {
 	v.paint(y, x, format, ' ')
 }
@@ -142,6 +179,8 @@ func (v *Screen) clearRow(y int, format Format) {
 		row[i] = ' '
 	}
 	v.Format.ClearRow(y, format)
+	v.Info[y].Wrapped = false
+	v.eraseImages(y, 0, len(row))
 	v.changed(y, false)
 }
 
@@ -154,7 +193,8 @@ func (v *Screen) paint(y, x int, format Format, r rune) {
 	row[x] = r
 	v.Content[y] = row
 	v.Format.Paint(y, x, format)
-	v.changed(y, false)
+	v.eraseImages(y, x, x+1)
+	v.changedCols(y, x, x+1)
 }
 
 func (v *Screen) moveRel(y, x int) {
@@ -183,6 +223,17 @@ func (v *Screen) changed(y int, moveOnly bool) {
 	}
 	v.ensureHeight(y)
 	v.Changes[y]++
+	if !moveOnly {
+		v.damaged(y, 0, v.Width)
+	}
+}
+
+// changedCols is like changed, but only the columns from x up to end were
+// modified.
+func (v *Screen) changedCols(y, x, end int) {
+	v.ensureHeight(y)
+	v.Changes[y]++
+	v.damaged(y, x, end)
 }
 
 func (v *Screen) ensureHeight(targetY int) {
@@ -193,6 +244,7 @@ func (v *Screen) ensureHeight(targetY int) {
 			v.Format.Paint(y, x, EmptyFormat)
 		}
 		v.Changes = append(v.Changes, 1)
+		v.Info = append(v.Info, LineInfo{})
 		v.Height++
 	}
 }
@@ -0,0 +1,281 @@
+package midterm
+
+import (
+	"fmt"
+	"io"
+	"iter"
+	"maps"
+
+	"github.com/muesli/termenv"
+)
+
+// Scrollback is a bounded buffer of the lines scrolled off the top of the main
+// screen. The zero value is an empty buffer with no limits.
+//
+// To save memory, trailing blanks are trimmed from each line, and each
+// distinct Format is stored only once and shared by every line using it.
+//
+// Lines beyond the limits are discarded, unless a Store is configured, in
+// which case they are moved there instead.
+//
+// Like the rest of the Terminal, a Scrollback is not safe for use while the
+// Terminal is being written to from another goroutine.
+type Scrollback struct {
+	// MaxLines is the maximum number of lines to keep in memory. If zero, the
+	// number of lines is unlimited.
+	MaxLines int
+
+	// MaxBytes is the approximate maximum number of bytes to use for storing
+	// lines in memory. If zero, the size is unlimited.
+	MaxBytes int
+
+	// Store receives the lines evicted from memory, if set. Its lines come
+	// before the ones in memory.
+	Store ScrollbackStore
+
+	// err is the first error returned by the Store.
+	err error
+
+	// dropped counts the lines discarded from the front of the buffer, so
+	// that lines can be identified by their position in the whole history.
+	dropped int
+
+	// lines are the stored lines, oldest first.
+	lines []scrollbackLine
+
+	// size is the approximate number of bytes used by lines.
+	size int
+
+	// formats are the distinct formats used by lines, referred to by index.
+	formats   []Format
+	formatIDs map[Format]int
+
+	// compactAt is the size of the format table at which to look for unused
+	// formats.
+	compactAt int
+}
+
+// scrollbackLine is a Line stored compactly.
+type scrollbackLine struct {
+	text string
+	runs []formatRun
+	info LineInfo
+}
+
+// formatRun is a run of cells sharing a format.
+type formatRun struct {
+	format, size int
+}
+
+// lineOverhead approximates the bytes used by a stored line besides its text
+// and format runs.
+const lineOverhead = 64
+
+func (l *scrollbackLine) size() int {
+	return lineOverhead + len(l.text) + len(l.runs)*16
+}
+
+// Len returns the number of lines in the buffer, including the Store.
+func (sb *Scrollback) Len() int {
+	return sb.stored() + len(sb.lines)
+}
+
+// Size returns the approximate number of bytes used to store the lines in
+// memory.
+func (sb *Scrollback) Size() int {
+	return sb.size
+}
+
+// Err returns the first error encountered using the Store, if any. Lines which
+// could not be read from the Store are returned as empty lines.
+func (sb *Scrollback) Err() error {
+	return sb.err
+}
+
+// stored returns the number of lines in the Store.
+func (sb *Scrollback) stored() int {
+	if sb.Store == nil {
+		return 0
+	}
+	return sb.Store.Len()
+}
+
+// Line returns the i'th line in the buffer, counting from the oldest.
+func (sb *Scrollback) Line(i int) Line {
+	stored := sb.stored()
+	if i < stored {
+		line, err := sb.Store.Line(i)
+		if err != nil {
+			sb.fail(err)
+		}
+		return line
+	}
+	return sb.memLine(i - stored)
+}
+
+// memLine returns the i'th line held in memory.
+func (sb *Scrollback) memLine(i int) Line {
+	l := &sb.lines[i]
+	content := []rune(l.text)
+	format := make([]Format, 0, len(content))
+	for _, run := range l.runs {
+		for range run.size {
+			format = append(format, sb.formats[run.format])
+		}
+	}
+	info := l.info
+	info.Tags = maps.Clone(info.Tags)
+	return Line{Content: content, Format: format, Info: info}
+}
+
+// All iterates over the lines in the buffer, oldest first.
+func (sb *Scrollback) All() iter.Seq2[int, Line] {
+	return func(yield func(int, Line) bool) {
+		for i := range sb.Len() {
+			if !yield(i, sb.Line(i)) {
+				return
+			}
+		}
+	}
+}
+
+// Clear removes all lines from the buffer.
+func (sb *Scrollback) Clear() {
+	sb.dropped += sb.Len()
+	sb.lines = nil
+	sb.size = 0
+	sb.formats = nil
+	sb.formatIDs = nil
+	sb.compactAt = 0
+	if sb.Store != nil {
+		if err := sb.Store.Clear(); err != nil {
+			sb.fail(err)
+		}
+	}
+}
+
+func (sb *Scrollback) fail(err error) {
+	dbg.Println("Scrollback: store failed:", err)
+	if sb.err == nil {
+		sb.err = err
+	}
+}
+
+// Render writes the i'th line to w.
+func (sb *Scrollback) Render(w io.Writer, i int) error {
+	return sb.RenderFgBg(w, i, nil, nil)
+}
+
+// RenderFgBg writes the i'th line to w, using fg and bg for cells with no
+// color of their own.
+func (sb *Scrollback) RenderFgBg(w io.Writer, i int, fg, bg termenv.Color) error {
+	if i < 0 || i >= sb.Len() {
+		return fmt.Errorf("scrollback line %d out of range", i)
+	}
+	return renderCells(w, sb.Line(i), 0, fg, bg)
+}
+
+// push appends a line to the buffer, evicting the oldest lines to stay within
+// the limits.
+func (sb *Scrollback) push(line Line) {
+	// trim trailing blanks
+	n := len(line.Content)
+	for n > 0 && line.Content[n-1] == ' ' && n <= len(line.Format) &&
+		(line.Format[n-1] == EmptyFormat || line.Format[n-1] == Reset) {
+		n--
+	}
+	l := scrollbackLine{
+		text: string(line.Content[:n]),
+		info: line.Info,
+	}
+	l.info.Tags = maps.Clone(l.info.Tags)
+	for i := 0; i < n; i++ {
+		var f Format
+		if i < len(line.Format) {
+			f = line.Format[i]
+		}
+		id := sb.formatID(f)
+		if len(l.runs) > 0 && l.runs[len(l.runs)-1].format == id {
+			l.runs[len(l.runs)-1].size++
+		} else {
+			l.runs = append(l.runs, formatRun{format: id, size: 1})
+		}
+	}
+	sb.lines = append(sb.lines, l)
+	sb.size += l.size()
+
+	var evicted []Line
+	for len(sb.lines) > 0 &&
+		(sb.MaxLines > 0 && len(sb.lines) > sb.MaxLines ||
+			sb.MaxBytes > 0 && sb.size > sb.MaxBytes) {
+		if sb.Store != nil {
+			evicted = append(evicted, sb.memLine(0))
+		} else {
+			sb.dropped++
+		}
+		sb.size -= sb.lines[0].size()
+		sb.lines[0] = scrollbackLine{}
+		sb.lines = sb.lines[1:]
+	}
+	if len(evicted) > 0 {
+		if err := sb.Store.Append(evicted...); err != nil {
+			sb.fail(err)
+		}
+	}
+	if len(sb.formats) >= max(sb.compactAt, minCompactFormats) {
+		sb.compactFormats()
+	}
+}
+
+// formatID returns the index of f in the format table, adding it if need be.
+func (sb *Scrollback) formatID(f Format) int {
+	if id, ok := sb.formatIDs[f]; ok {
+		return id
+	}
+	if sb.formatIDs == nil {
+		sb.formatIDs = map[Format]int{}
+	}
+	id := len(sb.formats)
+	sb.formats = append(sb.formats, f)
+	sb.formatIDs[f] = id
+	return id
+}
+
+// minCompactFormats is the size of the format table below which it is not
+// worth compacting.
+const minCompactFormats = 1024
+
+// compactFormats drops formats no longer used by any line from the format
+// table.
+func (sb *Scrollback) compactFormats() {
+	used := make([]bool, len(sb.formats))
+	var live int
+	for _, l := range sb.lines {
+		for _, run := range l.runs {
+			if !used[run.format] {
+				used[run.format] = true
+				live++
+			}
+		}
+	}
+	if live < len(sb.formats) {
+		remap := make([]int, len(sb.formats))
+		formats := make([]Format, 0, live)
+		clear(sb.formatIDs)
+		for id, f := range sb.formats {
+			if used[id] {
+				remap[id] = len(formats)
+				sb.formatIDs[f] = len(formats)
+				formats = append(formats, f)
+			}
+		}
+		sb.formats = formats
+		for _, l := range sb.lines {
+			for i := range l.runs {
+				l.runs[i].format = remap[l.runs[i].format]
+			}
+		}
+	}
+	// wait for the table to double before scanning again
+	sb.compactAt = 2 * len(sb.formats)
+}
@@ -0,0 +1,99 @@
+package midterm_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/muesli/termenv"
+	"github.com/stretchr/testify/require"
+	"github.com/vito/midterm"
+)
+
+func TestScrollback(t *testing.T) {
+	// lines returns the text of each line in the scrollback
+	lines := func(sb *midterm.Scrollback) []string {
+		var out []string
+		for _, line := range sb.All() {
+			out = append(out, string(line.Content))
+		}
+		return out
+	}
+
+	t.Run("stores lines scrolled off the main screen", func(t *testing.T) {
+		vt := midterm.NewTerminal(2, 10)
+		vt.Scrollback = &midterm.Scrollback{}
+		mustFprintf(t, vt, "\x1b[1mbold\x1b[0m text\r\nplain\r\nthree\r\nfour")
+
+		require.Equal(t, 2, vt.Scrollback.Len())
+		require.Equal(t, []string{"bold text", "plain"}, lines(vt.Scrollback))
+
+		line := vt.Scrollback.Line(0)
+		require.Len(t, line.Format, len(line.Content))
+		require.True(t, line.Format[0].IsBold())
+		require.True(t, line.Format[3].IsBold())
+		require.False(t, line.Format[4].IsBold())
+		require.False(t, line.Info.Written.IsZero())
+
+		var buf bytes.Buffer
+		require.NoError(t, vt.Scrollback.Render(&buf, 0))
+		require.Equal(t, "\x1b[1mbold\x1b[0m text\x1b[0m", buf.String())
+		require.Error(t, vt.Scrollback.Render(&buf, 2))
+	})
+
+	t.Run("ignores the alt screen", func(t *testing.T) {
+		vt := midterm.NewTerminal(2, 10)
+		vt.Scrollback = &midterm.Scrollback{}
+		mustFprintf(t, vt, "\x1b[?1049hone\r\ntwo\r\nthree")
+		require.Zero(t, vt.Scrollback.Len())
+	})
+
+	t.Run("keeps at most MaxLines lines", func(t *testing.T) {
+		vt := midterm.NewTerminal(1, 10)
+		vt.Scrollback = &midterm.Scrollback{MaxLines: 3}
+		for i := range 10 {
+			mustFprintf(t, vt, "line %d\r\n", i)
+		}
+		require.Equal(t, []string{"line 7", "line 8", "line 9"}, lines(vt.Scrollback))
+	})
+
+	t.Run("keeps at most MaxBytes bytes", func(t *testing.T) {
+		vt := midterm.NewTerminal(1, 100)
+		vt.Scrollback = &midterm.Scrollback{MaxBytes: 1000}
+		for i := range 100 {
+			mustFprintf(t, vt, "line %d\r\n", i)
+		}
+		require.LessOrEqual(t, vt.Scrollback.Size(), 1000)
+		require.Greater(t, vt.Scrollback.Len(), 1)
+		require.Equal(t, "line 99", string(vt.Scrollback.Line(vt.Scrollback.Len()-1).Content))
+	})
+
+	t.Run("forgets formats no longer in use", func(t *testing.T) {
+		vt := midterm.NewTerminal(1, 20)
+		vt.Scrollback = &midterm.Scrollback{MaxLines: 10}
+		for i := range 5000 {
+			mustFprintf(t, vt, "\x1b[38;2;%d;%d;0mcolorful\r\n", i%256, i/256)
+		}
+		require.Equal(t, 10, vt.Scrollback.Len())
+		line := vt.Scrollback.Line(9)
+		require.Equal(t, "colorful", string(line.Content))
+		require.Equal(t, termenv.RGBColor("#871300"), line.Format[0].Fg)
+	})
+
+	t.Run("is cleared by CSI 3 J", func(t *testing.T) {
+		vt := midterm.NewTerminal(2, 10)
+		vt.Scrollback = &midterm.Scrollback{}
+		mustFprintf(t, vt, "one\r\ntwo\r\nthree")
+		require.Equal(t, 1, vt.Scrollback.Len())
+		mustFprintf(t, vt, "\x1b[3J")
+		require.Zero(t, vt.Scrollback.Len())
+		require.Equal(t, "two", string(vt.Content[0][:3]))
+	})
+
+	t.Run("provides the output of commands scrolled off the screen", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 20)
+		vt.Scrollback = &midterm.Scrollback{}
+		mustFprintf(t, vt, promptStart+"$ "+commandStart+"seq 4\r\n"+outputStart)
+		mustFprintf(t, vt, "1\r\n2\r\n3\r\n4\r\n\x1b]133;D;0\x07"+promptStart+"$ ")
+		require.Equal(t, "1\n2\n3\n4", vt.CommandOutput(0))
+	})
+}
@@ -1,7 +1,12 @@
 package midterm
 
 import (
+	"cmp"
+	"math"
+	"regexp"
+	"slices"
 	"strings"
+	"unicode"
 	"unicode/utf8"
 )
 
@@ -12,69 +17,138 @@ type SearchHighlight struct {
 }
 
 // SearchMatch stores an individual match location for indexed navigation.
+// A match may span soft-wrapped rows, from Col on Row to End on EndRow.
 type SearchMatch struct {
 	Row, Col, End int
+	EndRow        int
+}
+
+// SearchOptions configures how Search matches its query.
+type SearchOptions struct {
+	// Regexp interprets the query as a Go regular expression rather than
+	// literal text.
+	Regexp bool
+
+	// CaseSensitive matches letters only in the case given.
+	CaseSensitive bool
+
+	// SmartCase matches case-sensitively only if the query contains an upper
+	// case letter. It has no effect if CaseSensitive is set.
+	SmartCase bool
+
+	// WholeWord only matches text which is not preceded or followed by a
+	// letter, digit or underscore.
+	WholeWord bool
+
+	// Scrollback searches the lines of the Scrollback as well as the screen.
+	// Matches there are on negative rows, counting back from -1 for the
+	// newest line, as with Position. Matches on rows scrolled off the top of
+	// the screen are kept rather than dropped.
+	Scrollback bool
 }
 
 // searchState holds cached state from the previous Search() call so that
 // subsequent calls with the same query can skip unchanged rows.
 type searchState struct {
-	query      string   // lowercased query from last search
-	queryRunes int      // rune length of query
-	changes    []uint64 // snapshot of Changes[] at last search
-	maxY       int      // MaxY at last search
+	query   string         // query from last search
+	opts    SearchOptions  // options from last search
+	re      *regexp.Regexp // compiled query
+	changes []uint64       // snapshot of Changes[] at last search
+	maxY    int            // MaxY at last search
+	pending []int          // scrollback rows scrolled off before being scanned
 }
 
 // Search finds all case-insensitive occurrences of query in Content
-// and populates SearchHighlights. If the query is the same as the
+// and populates SearchHighlights. Rows soft-wrapped together are searched
+// as one line, so matches may span rows. If the query is the same as the
 // previous call, only rows that have changed since then are re-scanned.
 // Returns the total match count.
 func (vt *Terminal) Search(query string) int {
+	// a literal query always compiles
+	count, _ := vt.SearchWithOptions(query, SearchOptions{})
+	return count
+}
+
+// SearchWithOptions is like Search, matching the query as configured by opts.
+// It returns an error if the query is not a valid regular expression.
+func (vt *Terminal) SearchWithOptions(query string, opts SearchOptions) (int, error) {
 	if query == "" {
 		vt.SearchClear()
-		return 0
+		return 0, nil
 	}
 
-	lowerQuery := strings.ToLower(query)
-	queryRuneLen := utf8.RuneCountInString(lowerQuery)
-
 	// Check if we can do an incremental update.
 	if vt.searchCache != nil &&
-		vt.searchCache.query == lowerQuery &&
+		vt.searchCache.query == query &&
+		vt.searchCache.opts == opts &&
 		vt.SearchHighlights != nil {
-		return vt.searchIncremental(lowerQuery, queryRuneLen)
+		return vt.searchIncremental(), nil
+	}
+
+	re, err := compileSearch(query, opts)
+	if err != nil {
+		return 0, err
 	}
 
 	// Full search.
-	return vt.searchFull(lowerQuery, queryRuneLen)
+	return vt.searchFull(query, opts, re), nil
+}
+
+// compileSearch compiles a query into a regular expression.
+func compileSearch(query string, opts SearchOptions) (*regexp.Regexp, error) {
+	expr := query
+	if !opts.Regexp {
+		expr = regexp.QuoteMeta(query)
+	}
+	caseSensitive := opts.CaseSensitive ||
+		opts.SmartCase && strings.IndexFunc(query, unicode.IsUpper) >= 0
+	if !caseSensitive {
+		expr = "(?i)" + expr
+	}
+	return regexp.Compile(expr)
 }
 
 // searchFull does a complete search from scratch.
-func (vt *Terminal) searchFull(lowerQuery string, queryRuneLen int) int {
+func (vt *Terminal) searchFull(query string, opts SearchOptions, re *regexp.Regexp) int {
 	vt.SearchMatches = vt.SearchMatches[:0]
 	if vt.SearchHighlights == nil {
 		vt.SearchHighlights = make(map[int][]SearchHighlight)
 	} else {
 		clear(vt.SearchHighlights)
 	}
+	vt.searchCache = &searchState{
+		query: query,
+		opts:  opts,
+		re:    re,
+	}
 
-	used := vt.MaxY + 1
-	for row := 0; row < used && row < len(vt.Content); row++ {
-		vt.searchRow(row, lowerQuery, queryRuneLen)
+	used := min(vt.MaxY+1, len(vt.Content))
+	for row := -vt.searchedScrollback(); row < used; {
+		start, end := vt.logicalRows(row, used)
+		vt.searchLine(start, end)
+		row = end + 1
 	}
 
-	vt.snapshotSearchState(lowerQuery, queryRuneLen)
+	vt.snapshotSearchState()
 	return len(vt.SearchMatches)
 }
 
 // searchIncremental re-scans only rows that have changed since the last
 // search, plus any new rows that appeared.
-func (vt *Terminal) searchIncremental(lowerQuery string, queryRuneLen int) int {
+func (vt *Terminal) searchIncremental() int {
 	cache := vt.searchCache
 	used := vt.MaxY + 1
+	vt.pruneSearch()
 
-	// Collect rows that need re-scanning.
+	// Collect rows that need re-scanning, starting with lines which scrolled
+	// off before they were scanned.
 	var dirtyRows []int
+	for _, row := range cache.pending {
+		if row >= -vt.searchedScrollback() {
+			dirtyRows = append(dirtyRows, row)
+		}
+	}
+	cache.pending = nil
 	limit := min(used, len(vt.Changes))
 	cachedLimit := min(limit, len(cache.changes))
 	for row := 0; row < cachedLimit; row++ {
@@ -91,37 +165,54 @@ func (vt *Terminal) searchIncremental(lowerQuery string, queryRuneLen int) int {
 		return len(vt.SearchMatches)
 	}
 
-	// Remove old matches/highlights for dirty rows.
+	// Rows are searched as part of the logical lines they belong to, so
+	// re-scan every row of the lines with dirty rows.
+	scanned := min(used, len(vt.Content))
+	var lines [][2]int
 	dirtySet := make(map[int]bool, len(dirtyRows))
+	for _, r := range dirtyRows {
+		if r >= scanned || dirtySet[r] {
+			continue
+		}
+		start, end := vt.logicalRows(r, scanned)
+		lines = append(lines, [2]int{start, end})
+		for row := start; row <= end; row++ {
+			dirtySet[row] = true
+		}
+	}
+
+	// Remove old highlights for dirty rows.
 	for _, r := range dirtyRows {
 		dirtySet[r] = true
+	}
+	for r := range dirtySet {
 		delete(vt.SearchHighlights, r)
 	}
 
-	// Filter out stale matches from SearchMatches.
+	// Filter out stale matches from SearchMatches, including ones which
+	// spanned into a dirty row from a clean one.
 	n := 0
 	for _, m := range vt.SearchMatches {
-		if !dirtySet[m.Row] {
-			vt.SearchMatches[n] = m
-			n++
+		if dirtySet[m.Row] || dirtySet[m.EndRow] {
+			vt.unhighlightMatch(m)
+			continue
 		}
+		vt.SearchMatches[n] = m
+		n++
 	}
 	vt.SearchMatches = vt.SearchMatches[:n]
 
-	// Re-scan dirty rows and collect new matches.
+	// Re-scan dirty lines and collect new matches.
+	slices.SortFunc(lines, func(a, b [2]int) int {
+		return cmp.Compare(a[0], b[0])
+	})
 	var newMatches []SearchMatch
-	for _, row := range dirtyRows {
-		if row >= len(vt.Content) {
-			continue
-		}
+	for _, line := range lines {
 		before := len(newMatches)
-		newMatches = vt.searchRowInto(row, lowerQuery, queryRuneLen, newMatches)
+		newMatches = vt.searchLineInto(line[0], line[1], newMatches)
 		// Also populate highlights.
 		for _, m := range newMatches[before:] {
-			vt.SearchHighlights[row] = append(vt.SearchHighlights[row], SearchHighlight{
-				Col: m.Col,
-				End: m.End,
-			})
+			vt.highlightMatch(m)
 		}
 	}
 
@@ -130,72 +221,160 @@ func (vt *Terminal) searchIncremental(lowerQuery string, queryRuneLen int) int {
 		vt.SearchMatches = mergeMatches(vt.SearchMatches, newMatches)
 	}
 
-	vt.snapshotSearchState(lowerQuery, queryRuneLen)
+	vt.snapshotSearchState()
 	return len(vt.SearchMatches)
 }
 
-// searchRow scans a single row and appends matches to SearchMatches and
-// SearchHighlights.
-func (vt *Terminal) searchRow(row int, lowerQuery string, queryRuneLen int) {
-	line := vt.Content[row]
-	lineStr := strings.ToLower(string(line))
-	searchFrom := 0
-	for {
-		idx := strings.Index(lineStr[searchFrom:], lowerQuery)
-		if idx < 0 {
-			break
-		}
-		col := utf8.RuneCountInString(lineStr[:searchFrom+idx])
-		end := col + queryRuneLen
-		vt.SearchHighlights[row] = append(vt.SearchHighlights[row], SearchHighlight{
-			Col: col,
-			End: end,
-		})
-		vt.SearchMatches = append(vt.SearchMatches, SearchMatch{
-			Row: row,
-			Col: col,
-			End: end,
-		})
-		searchFrom += idx + len(lowerQuery)
+// logicalRows returns the first and last rows of the logical line containing
+// row, i.e. the rows soft-wrapped together with it, among the searched
+// scrollback and the first used rows of the screen.
+func (vt *Terminal) logicalRows(row, used int) (int, int) {
+	first := -vt.searchedScrollback()
+	wrapped := func(row int) bool {
+		_, info, _ := vt.searchedRow(row)
+		return info.Wrapped
+	}
+	start, end := row, row
+	for start > first && wrapped(start-1) {
+		start--
+	}
+	for end < used-1 && wrapped(end) {
+		end++
+	}
+	return start, end
+}
+
+// searchedScrollback returns the number of lines of the Scrollback which are
+// searched.
+func (vt *Terminal) searchedScrollback() int {
+	if vt.searchCache == nil || !vt.searchCache.opts.Scrollback ||
+		vt.Scrollback == nil || vt.IsAlt {
+		return 0
+	}
+	return vt.Scrollback.Len()
+}
+
+// searchedRow returns the content and metadata of a row, which is in the
+// Scrollback if negative.
+func (vt *Terminal) searchedRow(row int) ([]rune, LineInfo, bool) {
+	switch {
+	case row >= 0 && row < len(vt.Content):
+		return vt.Content[row], vt.Info[row], true
+	case row < 0 && row >= -vt.searchedScrollback():
+		line := vt.Scrollback.Line(vt.Scrollback.Len() + row)
+		return line.Content, line.Info, true
+	default:
+		return nil, LineInfo{}, false
+	}
+}
+
+// searchLine scans the logical line made up of the rows from start through end
+// and appends matches to SearchMatches and SearchHighlights.
+func (vt *Terminal) searchLine(start, end int) {
+	before := len(vt.SearchMatches)
+	vt.SearchMatches = vt.searchLineInto(start, end, vt.SearchMatches)
+	for _, m := range vt.SearchMatches[before:] {
+		vt.highlightMatch(m)
 	}
 }
 
-// searchRowInto is like searchRow but appends to an external slice instead
+// searchLineInto is like searchLine but appends to an external slice instead
 // of vt.SearchMatches (used during incremental merge).
-func (vt *Terminal) searchRowInto(row int, lowerQuery string, queryRuneLen int, dst []SearchMatch) []SearchMatch {
-	line := vt.Content[row]
-	lineStr := strings.ToLower(string(line))
-	searchFrom := 0
-	for {
-		idx := strings.Index(lineStr[searchFrom:], lowerQuery)
-		if idx < 0 {
-			break
+func (vt *Terminal) searchLineInto(start, end int, dst []SearchMatch) []SearchMatch {
+	cache := vt.searchCache
+	var line []rune
+	var sizes []int
+	for row := start; row <= end; row++ {
+		content, _, _ := vt.searchedRow(row)
+		line = append(line, content...)
+		sizes = append(sizes, len(content))
+	}
+	lineStr := string(line)
+
+	// cell returns the row and column of the i'th rune of the line
+	cell := func(i int) (int, int) {
+		row := start
+		for row < end && i >= sizes[row-start] {
+			i -= sizes[row-start]
+			row++
+		}
+		return row, i
+	}
+
+	runes, offset := 0, 0
+	for _, loc := range cache.re.FindAllStringIndex(lineStr, -1) {
+		from, to := loc[0], loc[1]
+		if from == to {
+			// skip empty matches, which can't be highlighted
+			continue
 		}
-		col := utf8.RuneCountInString(lineStr[:searchFrom+idx])
-		end := col + queryRuneLen
+		if cache.opts.WholeWord && !wholeWord(lineStr, from, to) {
+			continue
+		}
+		runes += utf8.RuneCountInString(lineStr[offset:from])
+		size := utf8.RuneCountInString(lineStr[from:to])
+		row, col := cell(runes)
+		endRow, last := cell(runes + size - 1)
 		dst = append(dst, SearchMatch{
-			Row: row,
-			Col: col,
-			End: end,
+			Row:    row,
+			Col:    col,
+			End:    last + 1,
+			EndRow: endRow,
 		})
-		searchFrom += idx + len(lowerQuery)
+		runes, offset = runes+size, to
 	}
 	return dst
 }
 
+// matchSegments calls f with the column range covered by m on each of its
+// rows.
+func (vt *Terminal) matchSegments(m SearchMatch, f func(row, col, end int)) {
+	for row := m.Row; row <= max(m.Row, m.EndRow); row++ {
+		content, _, ok := vt.searchedRow(row)
+		if !ok {
+			continue
+		}
+		col, end := 0, len(content)
+		if row == m.Row {
+			col = m.Col
+		}
+		if row == max(m.Row, m.EndRow) {
+			end = m.End
+		}
+		f(row, col, end)
+	}
+}
+
+// highlightMatch adds the SearchHighlights for a match.
+func (vt *Terminal) highlightMatch(m SearchMatch) {
+	vt.matchSegments(m, func(row, col, end int) {
+		vt.SearchHighlights[row] = append(vt.SearchHighlights[row], SearchHighlight{
+			Col: col,
+			End: end,
+		})
+	})
+}
+
+// wholeWord reports whether s[start:end] is not adjacent to word characters.
+func wholeWord(s string, start, end int) bool {
+	before, _ := utf8.DecodeLastRuneInString(s[:start])
+	after, _ := utf8.DecodeRuneInString(s[end:])
+	return (start == 0 || !isWordChar(before)) && (end == len(s) || !isWordChar(after))
+}
+
+func isWordChar(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
 // snapshotSearchState captures the current Changes[] and MaxY so the next
 // Search() call can detect which rows are dirty.
-func (vt *Terminal) snapshotSearchState(lowerQuery string, queryRuneLen int) {
+func (vt *Terminal) snapshotSearchState() {
 	used := vt.MaxY + 1
 	limit := min(used, len(vt.Changes))
 	changes := make([]uint64, limit)
 	copy(changes, vt.Changes[:limit])
-	vt.searchCache = &searchState{
-		query:      lowerQuery,
-		queryRunes: queryRuneLen,
-		changes:    changes,
-		maxY:       vt.MaxY,
-	}
+	vt.searchCache.changes = changes
+	vt.searchCache.maxY = vt.MaxY
 }
 
 // mergeMatches merges two sorted-by-row match slices into one.
@@ -216,6 +395,136 @@ func mergeMatches(a, b []SearchMatch) []SearchMatch {
 	return result
 }
 
+// shiftSearch is called after the rows from start through end have moved by
+// delta rows, so that search results follow the text they matched. Matches
+// moved outside of the range are dropped, along with the ones cut in two,
+// unless they were pushed into the searched scrollback.
+func (vt *Terminal) shiftSearch(start, end, delta int, scrollback bool) {
+	if vt.SearchHighlights == nil && len(vt.SearchMatches) == 0 {
+		return
+	}
+	if scrollback && vt.Scrollback != nil &&
+		vt.searchCache != nil && vt.searchCache.opts.Scrollback {
+		// the scrollback moves along with the screen
+		start = math.MinInt
+		for i := range vt.searchCache.pending {
+			vt.searchCache.pending[i] += delta
+		}
+	}
+	inRange := func(row int) bool {
+		return row >= start && row <= end
+	}
+
+	// rescan is the rows left with part of a dropped match
+	var rescan []int
+	n := 0
+	for _, m := range vt.SearchMatches {
+		switch first, last := inRange(m.Row), inRange(m.EndRow); {
+		case !first && !last:
+		case first && last && inRange(m.Row+delta) && inRange(m.EndRow+delta):
+			m.Row += delta
+			m.EndRow += delta
+		default:
+			vt.unhighlightMatch(m)
+			for row := m.Row; row <= m.EndRow; row++ {
+				if !inRange(row) {
+					rescan = append(rescan, row)
+				} else if inRange(row + delta) {
+					rescan = append(rescan, row+delta)
+				}
+			}
+			continue
+		}
+		vt.SearchMatches[n] = m
+		n++
+	}
+	vt.SearchMatches = vt.SearchMatches[:n]
+
+	if vt.SearchHighlights != nil {
+		highlights := make(map[int][]SearchHighlight, len(vt.SearchHighlights))
+		for row, hls := range vt.SearchHighlights {
+			if inRange(row) {
+				row += delta
+				if !inRange(row) {
+					continue
+				}
+			}
+			highlights[row] = hls
+		}
+		vt.SearchHighlights = highlights
+	}
+
+	if cache := vt.searchCache; cache != nil {
+		// move the snapshot along with Changes, so that moved rows aren't
+		// considered dirty
+		changes := slices.Clone(cache.changes)
+		for row := range changes {
+			if !inRange(row) {
+				continue
+			}
+			if from := row - delta; inRange(from) && from < len(cache.changes) {
+				changes[row] = cache.changes[from]
+			} else {
+				// the row was vacated
+				changes[row] = 0
+			}
+		}
+		for _, row := range rescan {
+			if row >= 0 && row < len(changes) {
+				changes[row] = 0
+			}
+		}
+		cache.changes = changes
+	}
+}
+
+// searchScrollingOff is called before the first n rows of the main screen are
+// scrolled into the scrollback, to remember the ones which will need to be
+// scanned there.
+func (vt *Terminal) searchScrollingOff(n int) {
+	cache := vt.searchCache
+	if cache == nil || !cache.opts.Scrollback {
+		return
+	}
+	for row := 0; row < n && row < len(vt.Changes); row++ {
+		if row >= len(cache.changes) || vt.Changes[row] != cache.changes[row] {
+			// the row is shifted along with the rest by shiftSearch
+			cache.pending = append(cache.pending, row)
+		}
+	}
+}
+
+// pruneSearch drops the matches on lines no longer in the searched scrollback,
+// e.g. because they were evicted.
+func (vt *Terminal) pruneSearch() {
+	first := -vt.searchedScrollback()
+	n := 0
+	for _, m := range vt.SearchMatches {
+		if m.Row >= first {
+			vt.SearchMatches[n] = m
+			n++
+		}
+	}
+	vt.SearchMatches = vt.SearchMatches[:n]
+	for row := range vt.SearchHighlights {
+		if row < first {
+			delete(vt.SearchHighlights, row)
+		}
+	}
+}
+
+// unhighlightMatch removes the SearchHighlights for a match.
+func (vt *Terminal) unhighlightMatch(m SearchMatch) {
+	vt.matchSegments(m, func(row, col, end int) {
+		vt.SearchHighlights[row] = slices.DeleteFunc(vt.SearchHighlights[row], func(hl SearchHighlight) bool {
+			return hl.Col == col && hl.End == end
+		})
+		if len(vt.SearchHighlights[row]) == 0 {
+			delete(vt.SearchHighlights, row)
+		}
+	})
+}
+
 // SearchClear removes all search highlights, matches, and cached state.
 func (vt *Terminal) SearchClear() {
 	vt.SearchHighlights = nil
@@ -224,9 +533,22 @@ func (vt *Terminal) SearchClear() {
 }
 
 // SearchSetCurrent marks the match at the given index as "current"
-// (receives CurrentStyle). Returns the (row, col) of that match.
-// If idx is out of range, clears any current highlight.
+// (receives CurrentStyle). Returns the (row, col) of that match, where
+// negative rows are in the scrollback.
+// If idx is out of range, clears any current highlight and returns (-1, -1),
+// which can't be told apart from a match on the newest line of the scrollback;
+// use SearchSelectCurrent to find out whether idx was in range.
 func (vt *Terminal) SearchSetCurrent(idx int) (row, col int) {
+	row, col, ok := vt.SearchSelectCurrent(idx)
+	if !ok {
+		return -1, -1
+	}
+	return row, col
+}
+
+// SearchSelectCurrent is like SearchSetCurrent, also returning whether idx was
+// in range.
+func (vt *Terminal) SearchSelectCurrent(idx int) (row, col int, ok bool) {
 	// Clear all Current flags first.
 	for r, hls := range vt.SearchHighlights {
 		for i := range hls {
@@ -238,19 +560,20 @@ func (vt *Terminal) SearchSetCurrent(idx int) (row, col int) {
 	}
 
 	if idx < 0 || idx >= len(vt.SearchMatches) {
-		return -1, -1
+		return 0, 0, false
 	}
 
 	m := vt.SearchMatches[idx]
-	hls := vt.SearchHighlights[m.Row]
-	for i := range hls {
-		if hls[i].Col == m.Col && hls[i].End == m.End {
-			hls[i].Current = true
-			vt.SearchHighlights[m.Row] = hls
-			break
+	vt.matchSegments(m, func(row, col, end int) {
+		hls := vt.SearchHighlights[row]
+		for i := range hls {
+			if hls[i].Col == col && hls[i].End == end {
+				hls[i].Current = true
+				break
+			}
 		}
-	}
-	return m.Row, m.Col
+	})
+	return m.Row, m.Col, true
 }
 
 // SearchMatchCount returns the number of matches from the last Search call.
@@ -265,11 +588,9 @@ func (vt *Terminal) SearchMatchRows() []int {
 		return nil
 	}
 	var rows []int
-	lastRow := -1
 	for _, m := range vt.SearchMatches {
-		if m.Row != lastRow {
+		if len(rows) == 0 || m.Row != rows[len(rows)-1] {
 			rows = append(rows, m.Row)
-			lastRow = m.Row
 		}
 	}
 	return rows
@@ -1,6 +1,7 @@
 package midterm
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -92,6 +93,22 @@ func TestSearchSetCurrent(t *testing.T) {
 	}
 }
 
+func TestSearchSelectCurrent(t *testing.T) {
+	vt := NewTerminal(10, 40)
+	writeSearchInput(t, vt, "aaa\r\naaa\r\n")
+
+	vt.Search("aaa")
+	if row, col, ok := vt.SearchSelectCurrent(1); !ok || row != 1 || col != 0 {
+		t.Fatalf("expected (1, 0, true), got (%d, %d, %v)", row, col, ok)
+	}
+	if _, _, ok := vt.SearchSelectCurrent(2); ok {
+		t.Fatal("expected SelectCurrent(2) to be out of range")
+	}
+	if vt.SearchHighlights[1][0].Current {
+		t.Fatal("expected no current match after SelectCurrent(2)")
+	}
+}
+
 func TestSearchClear(t *testing.T) {
 	vt := NewTerminal(10, 40)
 	writeSearchInput(t, vt, "hello\r\n")
@@ -249,3 +266,341 @@ func TestSearchMultipleOnSameLine(t *testing.T) {
 		}
 	}
 }
+
+func searchCols(vt *Terminal) [][2]int {
+	var cols [][2]int
+	for _, m := range vt.SearchMatches {
+		cols = append(cols, [2]int{m.Col, m.End})
+	}
+	return cols
+}
+
+func TestSearchOptions(t *testing.T) {
+	vt := NewTerminal(5, 40)
+	writeSearchInput(t, vt, "Go gopher GO go_fmt (go)\r\n")
+
+	for _, tc := range []struct {
+		name  string
+		query string
+		opts  SearchOptions
+		want  [][2]int
+	}{
+		{"insensitive", "go", SearchOptions{}, [][2]int{{0, 2}, {3, 5}, {10, 12}, {13, 15}, {21, 23}}},
+		{"sensitive", "go", SearchOptions{CaseSensitive: true}, [][2]int{{3, 5}, {13, 15}, {21, 23}}},
+		{"smart lower", "go", SearchOptions{SmartCase: true}, [][2]int{{0, 2}, {3, 5}, {10, 12}, {13, 15}, {21, 23}}},
+		{"smart upper", "GO", SearchOptions{SmartCase: true}, [][2]int{{10, 12}}},
+		{"whole word", "go", SearchOptions{WholeWord: true}, [][2]int{{0, 2}, {10, 12}, {21, 23}}},
+		{"regexp", `g\w+`, SearchOptions{Regexp: true, CaseSensitive: true}, [][2]int{{3, 9}, {13, 19}, {21, 23}}},
+		{"regexp whole word", `\(?go\)?`, SearchOptions{Regexp: true, WholeWord: true}, [][2]int{{0, 2}, {10, 12}, {20, 24}}},
+		{"literal metacharacters", "(go)", SearchOptions{}, [][2]int{{20, 24}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			count, err := vt.SearchWithOptions(tc.query, tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != len(tc.want) {
+				t.Fatalf("expected %d matches, got %d", len(tc.want), count)
+			}
+			if got := searchCols(vt); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("matches: got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSearchRegexpInvalid(t *testing.T) {
+	vt := NewTerminal(5, 40)
+	writeSearchInput(t, vt, "hello\r\n")
+
+	if _, err := vt.SearchWithOptions("(", SearchOptions{Regexp: true}); err == nil {
+		t.Fatal("expected an error for an invalid regexp")
+	}
+
+	// empty matches are skipped
+	count, err := vt.SearchWithOptions("x*", SearchOptions{Regexp: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatalf("expected 0 matches, got %d", count)
+	}
+}
+
+func TestSearchIncrementalOptions(t *testing.T) {
+	vt := NewTerminal(5, 40)
+	writeSearchInput(t, vt, "foo Foo\r\n")
+
+	count, _ := vt.SearchWithOptions("Foo", SearchOptions{CaseSensitive: true})
+	if count != 1 {
+		t.Fatalf("expected 1 match, got %d", count)
+	}
+
+	// new rows are scanned with the same options
+	writeSearchInput(t, vt, "FOO Foo foo\r\n")
+	count, _ = vt.SearchWithOptions("Foo", SearchOptions{CaseSensitive: true})
+	if count != 2 {
+		t.Fatalf("expected 2 matches, got %d", count)
+	}
+
+	// changing the options searches from scratch
+	count, _ = vt.SearchWithOptions("Foo", SearchOptions{})
+	if count != 5 {
+		t.Fatalf("expected 5 matches, got %d", count)
+	}
+}
+
+func TestSearchMultibyte(t *testing.T) {
+	vt := NewTerminal(5, 40)
+	writeSearchInput(t, vt, "İx ✓ café CAFÉ\r\n")
+
+	vt.Search("café")
+	want := [][2]int{{5, 9}, {10, 14}}
+	if got := searchCols(vt); !reflect.DeepEqual(got, want) {
+		t.Fatalf("matches: got %v, want %v", got, want)
+	}
+}
+
+func TestSearchWrapped(t *testing.T) {
+	vt := NewTerminal(5, 10)
+	writeSearchInput(t, vt, "$ cat /usr/local/lib/x\r\nlib\r\n")
+
+	count := vt.Search("usr/local")
+	if count != 1 {
+		t.Fatalf("expected 1 match, got %d", count)
+	}
+	m := vt.SearchMatches[0]
+	if m != (SearchMatch{Row: 0, Col: 7, End: 6, EndRow: 1}) {
+		t.Fatalf("unexpected match: %+v", m)
+	}
+	want := map[int][]SearchHighlight{
+		0: {{Col: 7, End: 10}},
+		1: {{Col: 0, End: 6}},
+	}
+	if !reflect.DeepEqual(vt.SearchHighlights, want) {
+		t.Fatalf("highlights: got %+v, want %+v", vt.SearchHighlights, want)
+	}
+
+	// both halves become current
+	vt.SearchSetCurrent(0)
+	if !vt.SearchHighlights[0][0].Current || !vt.SearchHighlights[1][0].Current {
+		t.Fatalf("expected both halves to be current: %+v", vt.SearchHighlights)
+	}
+
+	// a match ending at the right margin stays on its row
+	vt.Search("/usr")
+	if m := vt.SearchMatches[0]; m != (SearchMatch{Row: 0, Col: 6, End: 10, EndRow: 0}) {
+		t.Fatalf("unexpected match: %+v", m)
+	}
+
+	// separate lines are not joined
+	if count := vt.Search("xlib"); count != 0 {
+		t.Fatalf("expected 0 matches, got %d", count)
+	}
+}
+
+func TestSearchIncrementalWrapped(t *testing.T) {
+	vt := NewTerminal(5, 10)
+	writeSearchInput(t, vt, "error: som")
+
+	if count := vt.Search("something"); count != 0 {
+		t.Fatalf("expected 0 matches, got %d", count)
+	}
+
+	// the rest of the word wraps onto a new row
+	writeSearchInput(t, vt, "ething failed\r\n")
+	if count := vt.Search("something"); count != 1 {
+		t.Fatalf("expected 1 match, got %d", count)
+	}
+	if m := vt.SearchMatches[0]; m != (SearchMatch{Row: 0, Col: 7, End: 6, EndRow: 1}) {
+		t.Fatalf("unexpected match: %+v", m)
+	}
+
+	// overwriting the second half removes the match from both rows
+	writeSearchInput(t, vt, "\x1b[2;1HXXX")
+	if count := vt.Search("something"); count != 0 {
+		t.Fatalf("expected 0 matches, got %d", count)
+	}
+	if len(vt.SearchHighlights) != 0 {
+		t.Fatalf("expected no highlights, got %+v", vt.SearchHighlights)
+	}
+}
+
+func TestSearchFollowsScrolling(t *testing.T) {
+	vt := NewTerminal(4, 20)
+	writeSearchInput(t, vt, "match 1\r\nfoo\r\nmatch 2\r\nbar")
+
+	if count := vt.Search("match"); count != 2 {
+		t.Fatalf("expected 2 matches, got %d", count)
+	}
+
+	// tail one more line, scrolling the first match off
+	writeSearchInput(t, vt, "\r\nmatch 3")
+	if len(vt.SearchMatches) != 1 || vt.SearchMatches[0].Row != 1 {
+		t.Fatalf("expected the second match to move to row 1: %+v", vt.SearchMatches)
+	}
+	want := map[int][]SearchHighlight{1: {{Col: 0, End: 5}}}
+	if !reflect.DeepEqual(vt.SearchHighlights, want) {
+		t.Fatalf("highlights: got %+v, want %+v", vt.SearchHighlights, want)
+	}
+
+	// only the new row is dirty
+	for row := 0; row < 3; row++ {
+		if vt.searchCache.changes[row] != vt.Changes[row] {
+			t.Fatalf("row %d should not be dirty", row)
+		}
+	}
+	if count := vt.Search("match"); count != 2 {
+		t.Fatalf("expected 2 matches, got %d", count)
+	}
+	if vt.SearchMatches[1].Row != 3 {
+		t.Fatalf("expected the new match on row 3: %+v", vt.SearchMatches)
+	}
+
+	// inserting a line pushes matches below it down, and the last one off
+	// the bottom
+	writeSearchInput(t, vt, "\x1b[1;1H\x1b[L")
+	if vt.SearchMatches[0].Row != 2 {
+		t.Fatalf("expected the match to move to row 2: %+v", vt.SearchMatches)
+	}
+	if _, ok := vt.SearchHighlights[2]; !ok {
+		t.Fatalf("expected highlights on row 2: %+v", vt.SearchHighlights)
+	}
+
+	// deleting lines pulls them back up
+	writeSearchInput(t, vt, "\x1b[2M")
+	if len(vt.SearchMatches) != 1 || vt.SearchMatches[0].Row != 0 {
+		t.Fatalf("expected the match to move to row 0: %+v", vt.SearchMatches)
+	}
+	if count := vt.Search("match"); count != 1 {
+		t.Fatalf("expected 1 match, got %d", count)
+	}
+}
+
+func TestSearchFollowsScrollingWrapped(t *testing.T) {
+	vt := NewTerminal(4, 10)
+	writeSearchInput(t, vt, "\r\n123456something")
+
+	if count := vt.Search("something"); count != 1 {
+		t.Fatalf("expected 1 match, got %d", count)
+	}
+
+	// scrolling the second row of the match away cuts it in two
+	writeSearchInput(t, vt, "\x1b[3;4r\x1b[4;1H\n")
+	if len(vt.SearchMatches) != 0 || len(vt.SearchHighlights) != 0 {
+		t.Fatalf("expected the match to be dropped: %+v %+v", vt.SearchMatches, vt.SearchHighlights)
+	}
+	if count := vt.Search("something"); count != 0 {
+		t.Fatalf("expected 0 matches, got %d", count)
+	}
+	if count := vt.Search("123456"); count != 1 {
+		t.Fatalf("expected 1 match, got %d", count)
+	}
+}
+
+func TestSearchClearedByReflow(t *testing.T) {
+	vt := NewTerminal(4, 10)
+	writeSearchInput(t, vt, "123456something")
+
+	if count := vt.Search("something"); count != 1 {
+		t.Fatalf("expected 1 match, got %d", count)
+	}
+
+	// the match moves to other cells when the line is rewrapped
+	vt.ResizeX(20)
+	if len(vt.SearchMatches) != 0 || len(vt.SearchHighlights) != 0 {
+		t.Fatalf("expected the match to be dropped: %+v %+v", vt.SearchMatches, vt.SearchHighlights)
+	}
+	if count := vt.Search("something"); count != 1 || vt.SearchMatches[0] != (SearchMatch{Row: 0, Col: 6, End: 15, EndRow: 0}) {
+		t.Fatalf("unexpected matches: %+v", vt.SearchMatches)
+	}
+}
+
+func TestSearchScrollback(t *testing.T) {
+	vt := NewTerminal(3, 10)
+	vt.Scrollback = &Scrollback{MaxLines: 6}
+	writeSearchInput(t, vt, "error 1\r\nok\r\nerror 2 abcdef\r\nok\r\n")
+
+	opts := SearchOptions{Scrollback: true}
+	count, err := vt.SearchWithOptions("error", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SearchMatch{
+		{Row: -3, Col: 0, End: 5, EndRow: -3},
+		{Row: -1, Col: 0, End: 5, EndRow: -1},
+	}
+	if count != len(want) || !reflect.DeepEqual(vt.SearchMatches, want) {
+		t.Fatalf("matches: got %+v, want %+v", vt.SearchMatches, want)
+	}
+
+	// a soft-wrapped line spanning the scrollback and the screen
+	count, _ = vt.SearchWithOptions("abcd", opts)
+	if count != 1 {
+		t.Fatalf("expected 1 match, got %d", count)
+	}
+	if m := vt.SearchMatches[0]; m != (SearchMatch{Row: -1, Col: 8, End: 2, EndRow: 0}) {
+		t.Fatalf("unexpected match: %+v", m)
+	}
+
+	// matches scrolled off the screen are kept
+	vt.SearchWithOptions("error", opts)
+	writeSearchInput(t, vt, "error 3\r\n\r\n")
+	count, _ = vt.SearchWithOptions("error", opts)
+	want = []SearchMatch{
+		{Row: -5, Col: 0, End: 5, EndRow: -5},
+		{Row: -3, Col: 0, End: 5, EndRow: -3},
+		{Row: 0, Col: 0, End: 5, EndRow: 0},
+	}
+	if count != len(want) || !reflect.DeepEqual(vt.SearchMatches, want) {
+		t.Fatalf("matches: got %+v, want %+v", vt.SearchMatches, want)
+	}
+
+	// lines scrolled off before being searched are scanned in the
+	// scrollback, and lines evicted from it are dropped
+	writeSearchInput(t, vt, "error 4\r\n\r\n\r\n")
+	count, _ = vt.SearchWithOptions("error", opts)
+	want = []SearchMatch{
+		{Row: -6, Col: 0, End: 5, EndRow: -6},
+		{Row: -3, Col: 0, End: 5, EndRow: -3},
+		{Row: -1, Col: 0, End: 5, EndRow: -1},
+	}
+	if count != len(want) || !reflect.DeepEqual(vt.SearchMatches, want) {
+		t.Fatalf("matches: got %+v, want %+v", vt.SearchMatches, want)
+	}
+	for row := range vt.SearchHighlights {
+		if row != -6 && row != -3 && row != -1 {
+			t.Fatalf("unexpected highlight on row %d: %+v", row, vt.SearchHighlights)
+		}
+	}
+
+	// the newest line of the scrollback is row -1
+	writeSearchInput(t, vt, "error 5\r\n\r\n\r\n")
+	count, _ = vt.SearchWithOptions("error 5", opts)
+	if count != 1 {
+		t.Fatalf("expected 1 match, got %d", count)
+	}
+	if rows := vt.SearchMatchRows(); !reflect.DeepEqual(rows, []int{-1}) {
+		t.Fatalf("match rows: got %v, want [-1]", rows)
+	}
+	if row, col, ok := vt.SearchSelectCurrent(0); !ok || row != -1 || col != 0 {
+		t.Fatalf("expected (-1, 0, true), got (%d, %d, %v)", row, col, ok)
+	}
+
+	// highlights are rendered in the scrollback
+	vt.SearchWithOptions("error", opts)
+	vp := vt.NewViewport(3)
+	vp.ScrollToTop()
+	var buf strings.Builder
+	if err := vp.RenderLine(&buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), vt.SearchMatchStyle.Render()+"error") {
+		t.Fatalf("expected a highlight: %q", buf.String())
+	}
+
+	// the screen alone is searched by default
+	if count := vt.Search("error"); count != 0 {
+		t.Fatalf("expected 0 matches, got %d", count)
+	}
+}
@@ -0,0 +1,198 @@
+package midterm
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// SelectionMode determines how a selection grows from its endpoints.
+type SelectionMode int
+
+const (
+	// SelectChar selects the cells between the endpoints, in reading order.
+	SelectChar SelectionMode = iota
+	// SelectWord is like SelectChar, extended to whole words at either end.
+	SelectWord
+	// SelectLine selects the whole lines between the endpoints, including the
+	// rows they are soft-wrapped across.
+	SelectLine
+	// SelectBlock selects the rectangle with the endpoints at its corners.
+	SelectBlock
+)
+
+func (m SelectionMode) String() string {
+	switch m {
+	case SelectChar:
+		return "char"
+	case SelectWord:
+		return "word"
+	case SelectLine:
+		return "line"
+	case SelectBlock:
+		return "block"
+	default:
+		return fmt.Sprintf("SelectionMode(%d)", int(m))
+	}
+}
+
+// DefaultWordSeparators are the characters besides whitespace which delimit
+// words when selecting by word.
+const DefaultWordSeparators = ",│`|:\"'()[]{}<>"
+
+// Selection is a selected region of the screen. Its endpoints are anchored to
+// the content they were on, so the selection follows the text as the screen
+// scrolls, including into the Scrollback.
+type Selection struct {
+	Mode SelectionMode
+
+	// Anchor is where the selection was started.
+	Anchor Position
+
+	// Head is where the selection was extended to. It may come before the
+	// Anchor.
+	Head Position
+}
+
+func (s *Selection) positions() []*Position {
+	return []*Position{&s.Anchor, &s.Head}
+}
+
+// StartSelection starts selecting at pos, replacing any existing selection.
+// Use Position to find the position of a cell of the screen, or
+// Viewport.Position for a row of a Viewport.
+func (v *Terminal) StartSelection(pos Position, mode SelectionMode) {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	v.trackLines()
+	v.selection = &Selection{Mode: mode, Anchor: pos, Head: pos}
+}
+
+// ExtendSelection moves the head of the selection to pos. It does nothing if
+// there is no selection.
+func (v *Terminal) ExtendSelection(pos Position) {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	if v.selection != nil {
+		v.selection.Head = pos
+	}
+}
+
+// ClearSelection removes the selection.
+func (v *Terminal) ClearSelection() {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	v.selection = nil
+}
+
+// Selection returns the current selection, if any. The selection is cleared
+// when switching between the main and alternate screens.
+func (v *Terminal) Selection() (Selection, bool) {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	if v.selection == nil {
+		return Selection{}, false
+	}
+	return *v.selection, true
+}
+
+// SelectedText returns the text of the selection, as by Text.
+func (v *Terminal) SelectedText() string {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	r, ok := v.selectionRange()
+	if !ok {
+		return ""
+	}
+	return v.rangeText(v.Screen, r)
+}
+
+// SelectionRange returns the range of cells covered by the selection, if any,
+// for exporting with Text, ANSI or HTMLRange.
+func (v *Terminal) SelectionRange() (Range, bool) {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	return v.selectionRange()
+}
+
+// selectionRange returns the range of the selection, extended according to
+// its mode.
+func (v *Terminal) selectionRange() (Range, bool) {
+	if v.selection == nil {
+		return Range{}, false
+	}
+	s := v.selection
+	r := Range{Start: s.Anchor, End: s.Head, Block: s.Mode == SelectBlock}.normalize()
+	switch s.Mode {
+	case SelectWord:
+		r.Start.Col, _ = v.wordAt(r.Start)
+		_, r.End.Col = v.wordAt(r.End)
+	case SelectLine:
+		for {
+			_, info, ok := v.lineAt(v.Screen, r.Start.Line-1)
+			if !ok || !info.Wrapped {
+				break
+			}
+			r.Start.Line--
+		}
+		for {
+			_, info, ok := v.lineAt(v.Screen, r.End.Line)
+			if !ok || !info.Wrapped {
+				break
+			}
+			r.End.Line++
+		}
+		r.Start.Col, r.End.Col = 0, math.MaxInt
+	}
+	return r, true
+}
+
+// selectedCols returns the range of columns selected on a line.
+func (v *Terminal) selectedCols(line int) (int, int, bool) {
+	r, ok := v.selectionRange()
+	if !ok {
+		return 0, 0, false
+	}
+	return r.cols(line)
+}
+
+// wordAt returns the first and last columns of the word at pos. A separator is
+// a word of its own.
+func (v *Terminal) wordAt(pos Position) (int, int) {
+	content, _, ok := v.lineAt(v.Screen, pos.Line)
+	if !ok || pos.Col < 0 || pos.Col >= len(content) {
+		return pos.Col, pos.Col
+	}
+	if v.isWordSeparator(content[pos.Col]) {
+		return pos.Col, pos.Col
+	}
+	from, to := pos.Col, pos.Col
+	for from > 0 && !v.isWordSeparator(content[from-1]) {
+		from--
+	}
+	for to < len(content)-1 && !v.isWordSeparator(content[to+1]) {
+		to++
+	}
+	return from, to
+}
+
+func (v *Terminal) isWordSeparator(r rune) bool {
+	return unicode.IsSpace(r) || strings.ContainsRune(v.WordSeparators, r)
+}
+
+// selectLine overrides the formats of the selected cells of a line with the
+// SelectionStyle.
+func (v *Terminal) selectLine(line int, l Line) Line {
+	from, to, ok := v.selectedCols(line)
+	if !ok || from >= len(l.Content) {
+		return l
+	}
+	format := make([]Format, len(l.Content))
+	copy(format, l.Format)
+	for col := from; col < min(to, len(format)); col++ {
+		format[col] = v.SelectionStyle
+	}
+	l.Format = format
+	return l
+}
@@ -0,0 +1,129 @@
+package midterm_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vito/midterm"
+)
+
+func TestSelection(t *testing.T) {
+	t.Run("selects characters in reading order", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 10)
+		mustFprintf(t, vt, "one two\r\nthree four\r\nfive")
+
+		vt.StartSelection(vt.Position(1, 6), midterm.SelectChar)
+		vt.ExtendSelection(vt.Position(0, 4))
+		require.Equal(t, "two\nthree f", vt.SelectedText())
+
+		sel, ok := vt.Selection()
+		require.True(t, ok)
+		require.Equal(t, midterm.SelectChar, sel.Mode)
+
+		vt.ClearSelection()
+		_, ok = vt.Selection()
+		require.False(t, ok)
+		require.Empty(t, vt.SelectedText())
+	})
+
+	t.Run("selects words", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 20)
+		mustFprintf(t, vt, "ls foo/bar,baz qux")
+
+		vt.StartSelection(vt.Position(0, 5), midterm.SelectWord)
+		require.Equal(t, "foo/bar", vt.SelectedText())
+		vt.ExtendSelection(vt.Position(0, 16))
+		require.Equal(t, "foo/bar,baz qux", vt.SelectedText())
+
+		vt.WordSeparators = "/,"
+		vt.StartSelection(vt.Position(0, 5), midterm.SelectWord)
+		require.Equal(t, "foo", vt.SelectedText())
+	})
+
+	t.Run("selects whole soft-wrapped lines", func(t *testing.T) {
+		vt := midterm.NewTerminal(4, 5)
+		mustFprintf(t, vt, "$ echo hi\r\nhi")
+
+		vt.StartSelection(vt.Position(1, 2), midterm.SelectLine)
+		require.Equal(t, "$ echo hi", vt.SelectedText())
+		vt.ExtendSelection(vt.Position(2, 0))
+		require.Equal(t, "$ echo hi\nhi", vt.SelectedText())
+	})
+
+	t.Run("selects blocks", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 10)
+		mustFprintf(t, vt, "abcdefgh\r\nijklmnop\r\nqrstuvwx")
+
+		vt.StartSelection(vt.Position(2, 5), midterm.SelectBlock)
+		vt.ExtendSelection(vt.Position(0, 2))
+		require.Equal(t, "cdef\nklmn\nstuv", vt.SelectedText())
+	})
+
+	t.Run("follows content as it scrolls", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 10)
+		vt.Scrollback = &midterm.Scrollback{}
+		mustFprintf(t, vt, "alpha\r\nbeta\r\ngamma")
+
+		vt.StartSelection(vt.Position(0, 0), midterm.SelectChar)
+		vt.ExtendSelection(vt.Position(1, 3))
+		require.Equal(t, "alpha\nbeta", vt.SelectedText())
+
+		// scroll the selection into scrollback
+		mustFprintf(t, vt, "\r\ndelta\r\nepsilon")
+		require.Equal(t, "alpha\nbeta", vt.SelectedText())
+
+		vp := vt.NewViewport(5)
+		var buf strings.Builder
+		require.NoError(t, vp.RenderLine(&buf, 0))
+		require.Contains(t, buf.String(), vt.SelectionStyle.Render()+"alpha")
+		sel, _ := vt.Selection()
+		require.Equal(t, sel.Anchor, vp.Position(0, 0))
+
+		// lines inserted above the selection push it down
+		vt.ClearSelection()
+		mustFprintf(t, vt, "\x1b[H")
+		vt.StartSelection(vt.Position(1, 0), midterm.SelectLine)
+		require.Equal(t, "delta", vt.SelectedText())
+		mustFprintf(t, vt, "\x1b[L")
+		require.Equal(t, "delta", vt.SelectedText())
+		sel, _ = vt.Selection()
+		require.Equal(t, vt.Position(2, 0), sel.Anchor)
+	})
+
+	t.Run("follows content as it reflows", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 10)
+		mustFprintf(t, vt, "hello world")
+
+		vt.StartSelection(vt.Position(1, 0), midterm.SelectChar)
+		vt.ExtendSelection(vt.Position(1, 0))
+		require.Equal(t, "d", vt.SelectedText())
+
+		vt.Resize(3, 20)
+		sel, _ := vt.Selection()
+		require.Equal(t, vt.Position(0, 10), sel.Anchor)
+		require.Equal(t, "d", vt.SelectedText())
+	})
+
+	t.Run("is cleared when switching screens", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 10)
+		mustFprintf(t, vt, "hello")
+		vt.StartSelection(vt.Position(0, 0), midterm.SelectWord)
+		mustFprintf(t, vt, "\x1b[?1049h")
+		_, ok := vt.Selection()
+		require.False(t, ok)
+	})
+
+	t.Run("is highlighted when rendered", func(t *testing.T) {
+		vt := midterm.NewTerminal(2, 10)
+		mustFprintf(t, vt, "abcdef")
+		vt.StartSelection(vt.Position(0, 1), midterm.SelectChar)
+		vt.ExtendSelection(vt.Position(0, 2))
+
+		var buf strings.Builder
+		require.NoError(t, vt.RenderLine(&buf, 0))
+		require.Contains(t, buf.String(), "a"+vt.SelectionStyle.Render()+"bc")
+
+		require.Contains(t, vt.HTML(), `">bc</span>`)
+	})
+}
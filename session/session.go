@@ -0,0 +1,186 @@
+// Package session runs commands under a pty attached to a midterm Terminal.
+package session
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/vito/midterm"
+)
+
+// Options configures a Session.
+type Options struct {
+	// Output is called with each chunk of output from the command, on the
+	// goroutine reading it, in place of writing it to the terminal. It must
+	// write the output to the terminal itself, e.g. by sending it to a Bubble
+	// Tea program as a teaterm.OutputMsg.
+	//
+	// By default the output is written to the terminal on the reading
+	// goroutine. Terminal.Write takes the terminal's lock, so the terminal may
+	// still be rendered from other goroutines, but anything reading its
+	// exported fields directly must synchronize with the session, e.g. by
+	// setting Output.
+	Output func([]byte)
+}
+
+// Session is a command running under a pty attached to a Terminal.
+//
+// Output from the command is written to the terminal, responses to queries
+// are written back to the command, and resizing the terminal resizes the pty.
+// Writes to the session are input to the command.
+//
+// The session owns the terminal's ForwardResponses writer and OnResize hook
+// until it is closed: Start replaces them, and Close clears them rather than
+// restoring what was there before.
+type Session struct {
+	vt  *midterm.Terminal
+	cmd *exec.Cmd
+	pty *os.File
+
+	// done is closed once the command has exited and its output has been
+	// read.
+	done chan struct{}
+
+	// err is the error from waiting for the command.
+	err error
+
+	closeOnce sync.Once
+}
+
+// readSize is the size of the buffer output is read into.
+const readSize = 32 * 1024
+
+// Start starts cmd under a pty the size of vt, writing its output to vt. The
+// command is killed if ctx is canceled.
+func Start(ctx context.Context, vt *midterm.Terminal, cmd *exec.Cmd, opts Options) (*Session, error) {
+	ptmx, err := pty.StartWithSize(cmd, winsize(vt.Height, vt.Width))
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{
+		vt:   vt,
+		cmd:  cmd,
+		pty:  ptmx,
+		done: make(chan struct{}),
+	}
+	vt.SetForwardResponses(ptmx)
+	vt.OnResize(func(rows, cols int) {
+		// the pty is closed once the command is done, which is fine
+		_ = pty.Setsize(ptmx, winsize(rows, cols))
+	})
+
+	output := opts.Output
+	if output == nil {
+		output = func(p []byte) {
+			_, _ = vt.Write(p)
+		}
+	}
+	read := make(chan struct{})
+	go func() {
+		defer close(read)
+		for {
+			buf := make([]byte, readSize)
+			n, err := ptmx.Read(buf)
+			if n > 0 {
+				output(buf[:n])
+			}
+			if err != nil {
+				// reading fails with EIO once the command and anything else
+				// with the pty open have exited
+				return
+			}
+		}
+	}()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = cmd.Process.Kill()
+		case <-s.done:
+		}
+	}()
+
+	go func() {
+		err := cmd.Wait()
+		<-read
+		_ = ptmx.Close()
+		s.err = err
+		close(s.done)
+	}()
+
+	return s, nil
+}
+
+// Write writes input to the command.
+func (s *Session) Write(p []byte) (int, error) {
+	return s.pty.Write(p)
+}
+
+// Done returns a channel closed once the command has exited and its output
+// has been written to the terminal.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Wait waits for the command to exit and its output to be written to the
+// terminal, returning its error, e.g. an *exec.ExitError for a non-zero exit
+// status.
+func (s *Session) Wait() error {
+	<-s.done
+	return s.err
+}
+
+// ExitCode returns the exit code of the command, or -1 if it hasn't exited or
+// was killed by a signal.
+func (s *Session) ExitCode() int {
+	select {
+	case <-s.done:
+		return s.cmd.ProcessState.ExitCode()
+	default:
+		return -1
+	}
+}
+
+// hangupGrace is how long Close waits for the command to exit after hanging
+// up before killing it.
+const hangupGrace = time.Second
+
+// Close hangs up on the command if it is still running, killing it if it
+// doesn't exit soon after, waits for it to exit, and detaches the session from
+// the terminal, clearing its ForwardResponses writer and OnResize hook.
+func (s *Session) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		select {
+		case <-s.done:
+		default:
+			// like closing a terminal window
+			_ = s.cmd.Process.Signal(syscall.SIGHUP)
+			select {
+			case <-s.done:
+			case <-time.After(hangupGrace):
+				_ = s.cmd.Process.Kill()
+			}
+		}
+		<-s.done
+		s.vt.SetForwardResponses(nil)
+		s.vt.OnResize(nil)
+
+		var exit *exec.ExitError
+		if s.err != nil && !errors.As(s.err, &exit) {
+			// exiting is the point, but failing to wait is worth reporting
+			err = s.err
+		}
+	})
+	return err
+}
+
+func winsize(rows, cols int) *pty.Winsize {
+	return &pty.Winsize{Rows: uint16(rows), Cols: uint16(cols)}
+}
@@ -0,0 +1,128 @@
+package session_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vito/midterm"
+	"github.com/vito/midterm/session"
+)
+
+func TestSession(t *testing.T) {
+	t.Run("writes output to the terminal", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 20)
+		s, err := session.Start(context.Background(), vt, exec.Command("sh", "-c", "printf hello; stty size"), session.Options{})
+		require.NoError(t, err)
+		require.NoError(t, s.Wait())
+		require.Equal(t, 0, s.ExitCode())
+		require.Equal(t, "hello3 20", strings.TrimSpace(string(vt.Content[0])))
+		require.NoError(t, s.Close())
+	})
+
+	t.Run("can be rendered while output is written", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 20)
+		s, err := session.Start(context.Background(), vt, exec.Command("sh", "-c", "for i in $(seq 100); do echo $i; done"), session.Options{})
+		require.NoError(t, err)
+		defer s.Close()
+
+		for running := true; running; {
+			select {
+			case <-s.Done():
+				running = false
+			default:
+			}
+			require.NoError(t, vt.Render(io.Discard))
+		}
+		require.NoError(t, s.Wait())
+		require.Equal(t, "100", strings.TrimSpace(vt.Text(midterm.Range{Start: vt.Position(1, 0), End: vt.Position(1, 19)})))
+	})
+
+	t.Run("detaches from the terminal when closed", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 20)
+		s, err := session.Start(context.Background(), vt, exec.Command("true"), session.Options{})
+		require.NoError(t, err)
+
+		// queries may keep arriving from elsewhere while closing
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for range 100 {
+				_, _ = vt.Write([]byte("\x1b[6n"))
+			}
+		}()
+		require.NoError(t, s.Close())
+		<-done
+
+		require.Nil(t, vt.ForwardResponses)
+		vt.Resize(5, 30)
+	})
+
+	t.Run("resizes the pty with the terminal", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 20)
+		s, err := session.Start(context.Background(), vt, exec.Command("sh", "-c", "read line; stty size"), session.Options{})
+		require.NoError(t, err)
+		defer s.Close()
+
+		vt.Resize(5, 30)
+		_, err = s.Write([]byte("\n"))
+		require.NoError(t, err)
+		require.NoError(t, s.Wait())
+		require.Equal(t, "5 30", strings.TrimSpace(string(vt.Content[1])))
+	})
+
+	t.Run("reports the exit status", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 20)
+		s, err := session.Start(context.Background(), vt, exec.Command("sh", "-c", "exit 3"), session.Options{})
+		require.NoError(t, err)
+		var exit *exec.ExitError
+		require.True(t, errors.As(s.Wait(), &exit))
+		require.Equal(t, 3, s.ExitCode())
+		require.NoError(t, s.Close())
+	})
+
+	t.Run("passes output to a hook", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 20)
+		var output []byte
+		s, err := session.Start(context.Background(), vt, exec.Command("echo", "hi"), session.Options{
+			Output: func(p []byte) {
+				output = append(output, p...)
+			},
+		})
+		require.NoError(t, err)
+		require.NoError(t, s.Wait())
+		require.Equal(t, "hi\r\n", string(output))
+		require.Empty(t, strings.TrimSpace(string(vt.Content[0])))
+	})
+
+	t.Run("kills the command when canceled", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 20)
+		ctx, cancel := context.WithCancel(context.Background())
+		s, err := session.Start(ctx, vt, exec.Command("sleep", "10"), session.Options{})
+		require.NoError(t, err)
+		require.Equal(t, -1, s.ExitCode())
+
+		cancel()
+		select {
+		case <-s.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("command was not killed")
+		}
+		require.Error(t, s.Wait())
+		require.NoError(t, s.Close())
+	})
+
+	t.Run("hangs up when closed", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 20)
+		s, err := session.Start(context.Background(), vt, exec.Command("sleep", "10"), session.Options{})
+		require.NoError(t, err)
+		require.NoError(t, s.Close())
+		require.Error(t, s.Wait())
+		require.Nil(t, vt.ForwardResponses)
+	})
+}
@@ -0,0 +1,178 @@
+package midterm
+
+import "strconv"
+
+// CommandBlock is a command run at a shell prompt, as delimited by shell
+// integration marks (OSC 133).
+type CommandBlock struct {
+	// Prompt is the text of the prompt.
+	Prompt string
+
+	// Command is the command line that was entered.
+	Command string
+
+	// PromptStart is where the prompt begins (OSC 133 ; A).
+	PromptStart Position
+
+	// CommandStart is where the command line begins (OSC 133 ; B).
+	CommandStart Position
+
+	// OutputStart and OutputEnd delimit the output of the command (OSC 133 ; C
+	// through OSC 133 ; D). If the command never finished, the output ends
+	// where the next prompt begins. The range is empty if the command produced
+	// no output, was never run, or is still running.
+	OutputStart, OutputEnd Position
+
+	// Finished indicates that the command has completed.
+	Finished bool
+
+	// ExitCode is the exit status of the command, or -1 if the shell did not
+	// report one.
+	ExitCode int
+}
+
+// shellIntegration tracks shell integration marks. It is only allocated once
+// the first mark is received.
+type shellIntegration struct {
+	// blocks are the command blocks, oldest first.
+	blocks []*CommandBlock
+}
+
+// shellMark handles an OSC 133 shell integration mark.
+func (v *Terminal) shellMark(params []string) {
+	if len(params) == 0 || params[0] == "" {
+		dbg.Println("ShellMark: missing mark")
+		return
+	}
+	if v.IsAlt {
+		dbg.Printf("ShellMark: %s on alt screen (ignored)\n", params[0])
+		return
+	}
+	if v.shell == nil {
+		v.shell = &shellIntegration{}
+		v.trackLines()
+	}
+	s := v.shell
+	pos := v.position(v.Cursor.Y, v.Cursor.X)
+
+	var cur *CommandBlock
+	if len(s.blocks) > 0 {
+		cur = s.blocks[len(s.blocks)-1]
+	}
+
+	switch params[0] {
+	case "A":
+		if cur != nil && !cur.Finished {
+			// no D was sent, so the output ends where the next prompt begins
+			cur.OutputEnd = pos
+		}
+		s.blocks = append(s.blocks, &CommandBlock{
+			PromptStart:  pos,
+			CommandStart: pos,
+			OutputStart:  pos,
+			OutputEnd:    pos,
+			ExitCode:     -1,
+		})
+	case "B":
+		if cur == nil || cur.Finished {
+			dbg.Println("ShellMark: B without prompt (ignored)")
+			return
+		}
+		cur.Prompt = v.textBetween(cur.PromptStart, pos)
+		cur.CommandStart = pos
+		cur.OutputStart = pos
+		cur.OutputEnd = pos
+	case "C":
+		if cur == nil || cur.Finished {
+			dbg.Println("ShellMark: C without prompt (ignored)")
+			return
+		}
+		cur.Command = v.textBetween(cur.CommandStart, pos)
+		cur.OutputStart = pos
+		cur.OutputEnd = pos
+	case "D":
+		if cur == nil || cur.Finished {
+			dbg.Println("ShellMark: D without prompt (ignored)")
+			return
+		}
+		cur.OutputEnd = pos
+		cur.Finished = true
+		if len(params) > 1 {
+			if code, err := strconv.Atoi(params[1]); err == nil {
+				cur.ExitCode = code
+			}
+		}
+	default:
+		dbg.Printf("ShellMark: %q (ignored)\n", params[0])
+	}
+}
+
+// CommandBlocks returns the command blocks delimited by shell integration
+// marks, oldest first. Blocks are dropped once they have scrolled off the
+// screen and out of the Scrollback.
+func (v *Terminal) CommandBlocks() []CommandBlock {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	if v.shell == nil {
+		return nil
+	}
+	blocks := make([]CommandBlock, len(v.shell.blocks))
+	for i, b := range v.shell.blocks {
+		blocks[i] = *b
+	}
+	return blocks
+}
+
+// CommandOutput returns the output of the i'th command block as plain text,
+// with trailing whitespace removed. Output of a command that is still running
+// extends to the cursor. Lines scrolled off the screen are only included if
+// they are still in the Scrollback.
+func (v *Terminal) CommandOutput(i int) string {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	if v.shell == nil || i < 0 || i >= len(v.shell.blocks) {
+		return ""
+	}
+	b := v.shell.blocks[i]
+	end := b.OutputEnd
+	if !b.Finished && i == len(v.shell.blocks)-1 && !v.IsAlt {
+		end = v.position(v.Cursor.Y, v.Cursor.X)
+	}
+	return v.textBetween(b.OutputStart, end)
+}
+
+// pruneBlocks drops the command blocks which ended before the oldest line
+// still available, i.e. on the screen or in the Scrollback, so that they don't
+// pile up for ever. The last block is always kept, as it may still be running.
+func (v *Terminal) pruneBlocks() {
+	if v.shell == nil {
+		return
+	}
+	oldest := v.scrolled()
+	if v.Scrollback != nil {
+		oldest -= v.Scrollback.Len()
+	}
+	blocks := v.shell.blocks
+	var n int
+	for n < len(blocks)-1 && blocks[n].OutputEnd.Line < oldest {
+		n++
+	}
+	if n > 0 {
+		clear(blocks[:n])
+		v.shell.blocks = blocks[n:]
+	}
+}
+
+// positions returns the positions of the shell integration marks.
+func (s *shellIntegration) positions() []*Position {
+	var positions []*Position
+	for _, b := range s.blocks {
+		positions = append(positions,
+			&b.PromptStart,
+			&b.CommandStart,
+			&b.OutputStart,
+			&b.OutputEnd,
+		)
+	}
+	return positions
+}
@@ -0,0 +1,129 @@
+package midterm_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vito/midterm"
+)
+
+const (
+	promptStart  = "\x1b]133;A\x07"
+	commandStart = "\x1b]133;B\x07"
+	outputStart  = "\x1b]133;C\x07"
+)
+
+func TestShellIntegration(t *testing.T) {
+	t.Run("records command blocks", func(t *testing.T) {
+		vt := midterm.NewTerminal(10, 20)
+		mustFprintf(t, vt, promptStart+"$ "+commandStart+"ls\r\n"+outputStart)
+		mustFprintf(t, vt, "a.txt\r\nb.txt\r\n\x1b]133;D;0\x07")
+		mustFprintf(t, vt, promptStart+"$ "+commandStart+"false\r\n"+outputStart)
+		mustFprintf(t, vt, "\x1b]133;D;1\x07"+promptStart+"$ "+commandStart+"sleep 10\r\n"+outputStart+"zzz")
+
+		blocks := vt.CommandBlocks()
+		require.Len(t, blocks, 3)
+
+		require.Equal(t, "$", blocks[0].Prompt)
+		require.Equal(t, "ls", blocks[0].Command)
+		require.Equal(t, midterm.Position{Line: 0, Col: 0}, blocks[0].PromptStart)
+		require.Equal(t, midterm.Position{Line: 0, Col: 2}, blocks[0].CommandStart)
+		require.Equal(t, midterm.Position{Line: 1, Col: 0}, blocks[0].OutputStart)
+		require.Equal(t, midterm.Position{Line: 3, Col: 0}, blocks[0].OutputEnd)
+		require.True(t, blocks[0].Finished)
+		require.Equal(t, 0, blocks[0].ExitCode)
+		require.Equal(t, "a.txt\nb.txt", vt.CommandOutput(0))
+
+		require.Equal(t, "false", blocks[1].Command)
+		require.True(t, blocks[1].Finished)
+		require.Equal(t, 1, blocks[1].ExitCode)
+		require.Empty(t, vt.CommandOutput(1))
+
+		require.Equal(t, "sleep 10", blocks[2].Command)
+		require.False(t, blocks[2].Finished)
+		require.Equal(t, -1, blocks[2].ExitCode)
+		require.Equal(t, "zzz", vt.CommandOutput(2))
+	})
+
+	t.Run("keeps marks on their lines as the screen scrolls", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 20)
+		mustFprintf(t, vt, promptStart+"$ "+commandStart+"seq 4\r\n"+outputStart)
+		mustFprintf(t, vt, "1\r\n2\r\n3\r\n4\r\n\x1b]133;D\x07")
+		mustFprintf(t, vt, promptStart+"$ "+commandStart+"echo hi\r\n"+outputStart+"hi\r\n")
+
+		blocks := vt.CommandBlocks()
+		require.Len(t, blocks, 2)
+		require.Equal(t, midterm.Position{Line: 0, Col: 0}, blocks[0].PromptStart)
+		require.Equal(t, midterm.Position{Line: 5, Col: 0}, blocks[0].OutputEnd)
+		require.Equal(t, -1, blocks[0].ExitCode)
+		require.Equal(t, midterm.Position{Line: 5, Col: 0}, blocks[1].PromptStart)
+		require.Equal(t, midterm.Position{Line: 6, Col: 0}, blocks[1].OutputStart)
+		require.Equal(t, "echo hi", blocks[1].Command)
+		require.Equal(t, "hi", vt.CommandOutput(1))
+
+		// the first command's output has scrolled off the screen entirely
+		require.Empty(t, vt.CommandOutput(0))
+	})
+
+	t.Run("joins soft-wrapped lines", func(t *testing.T) {
+		vt := midterm.NewTerminal(10, 10)
+		mustFprintf(t, vt, promptStart+"~/src/app $ "+commandStart+"echo helloworld\r\n"+outputStart)
+		mustFprintf(t, vt, "helloworld helloworld\r\n\x1b]133;D;0\x07")
+
+		blocks := vt.CommandBlocks()
+		require.Len(t, blocks, 1)
+		require.Equal(t, "~/src/app $", blocks[0].Prompt)
+		require.Equal(t, "echo helloworld", blocks[0].Command)
+		require.Equal(t, "helloworld helloworld", vt.CommandOutput(0))
+	})
+
+	t.Run("drops blocks which scroll out of the history", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 20)
+		vt.Scrollback = &midterm.Scrollback{MaxLines: 4}
+		for i := range 100 {
+			mustFprintf(t, vt, promptStart+"$ "+commandStart+"echo %d\r\n"+outputStart+"%d\r\n\x1b]133;D;0\x07", i, i)
+		}
+		mustFprintf(t, vt, promptStart+"$ "+commandStart+"sleep 10\r\n"+outputStart)
+
+		// 3 rows of screen and 4 of scrollback hold the last 3 commands
+		blocks := vt.CommandBlocks()
+		require.Len(t, blocks, 4)
+		require.Equal(t, "echo 97", blocks[0].Command)
+		require.Equal(t, "97", vt.CommandOutput(0))
+		require.Equal(t, "sleep 10", blocks[3].Command)
+
+		// without a Scrollback, only what's on the screen is kept, except for
+		// the last block which may still be running
+		vt = midterm.NewTerminal(3, 20)
+		for i := range 100 {
+			mustFprintf(t, vt, promptStart+"$ "+commandStart+"echo %d\r\n"+outputStart+"%d\r\n\x1b]133;D;0\x07", i, i)
+		}
+		mustFprintf(t, vt, "\r\n\r\n\r\n\r\n")
+		blocks = vt.CommandBlocks()
+		require.Len(t, blocks, 1)
+		require.Equal(t, "echo 99", blocks[0].Command)
+	})
+
+	t.Run("ends the output of an unfinished command at the next prompt", func(t *testing.T) {
+		vt := midterm.NewTerminal(10, 20)
+		mustFprintf(t, vt, promptStart+"$ "+commandStart+"cat\r\n"+outputStart+"meow\r\n")
+		mustFprintf(t, vt, promptStart+"$ ")
+
+		blocks := vt.CommandBlocks()
+		require.Len(t, blocks, 2)
+		require.False(t, blocks[0].Finished)
+		require.Equal(t, midterm.Position{Line: 2, Col: 0}, blocks[0].OutputEnd)
+		require.Equal(t, "meow", vt.CommandOutput(0))
+	})
+
+	t.Run("follows lines inserted above", func(t *testing.T) {
+		vt := midterm.NewTerminal(10, 20)
+		mustFprintf(t, vt, "\r\n"+promptStart+"$ "+commandStart)
+		mustFprintf(t, vt, "\x1b[H\x1b[2L")
+
+		blocks := vt.CommandBlocks()
+		require.Len(t, blocks, 1)
+		require.Equal(t, midterm.Position{Line: 3, Col: 0}, blocks[0].PromptStart)
+		require.Equal(t, midterm.Position{Line: 3, Col: 2}, blocks[0].CommandStart)
+	})
+}
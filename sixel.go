@@ -0,0 +1,236 @@
+package midterm
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/lucasb-eyer/go-colorful"
+)
+
+// maxSixelSize bounds the dimensions of a decoded sixel image so that a
+// runaway stream can't allocate unbounded memory.
+const maxSixelSize = 4096
+
+// sixelPalette is the VT340's default color palette.
+var sixelPalette = [16]color.RGBA{
+	{0, 0, 0, 255},
+	{51, 51, 204, 255},
+	{204, 36, 36, 255},
+	{51, 204, 51, 255},
+	{204, 51, 204, 255},
+	{51, 204, 204, 255},
+	{204, 204, 51, 255},
+	{120, 120, 120, 255},
+	{69, 69, 69, 255},
+	{87, 87, 153, 255},
+	{153, 69, 69, 255},
+	{87, 153, 87, 255},
+	{153, 87, 153, 255},
+	{87, 153, 153, 255},
+	{153, 153, 87, 255},
+	{204, 204, 204, 255},
+}
+
+// sixelDecoder incrementally decodes the body of a sixel DCS string.
+type sixelDecoder struct {
+	palette [256]color.RGBA
+	color   uint8
+
+	// transparent indicates that pixels which are never drawn are left
+	// transparent rather than being filled with color 0.
+	transparent bool
+
+	// x and y are the position of the next sixel; y is the top of the current
+	// six-pixel band.
+	x, y int
+
+	// width and height are the extent of the image: either declared by the
+	// raster attributes or the furthest pixel drawn.
+	width, height int
+
+	pix *image.RGBA
+
+	// cmd is the pending control character ('#', '!', or '"') whose numeric
+	// parameters are being collected in params.
+	cmd    byte
+	params []int
+}
+
+func newSixelDecoder(params [][]uint16) *sixelDecoder {
+	d := &sixelDecoder{
+		transparent: len(params) > 1 && len(params[1]) > 0 && params[1][0] == 1,
+		pix:         image.NewRGBA(image.Rect(0, 0, 0, 0)),
+	}
+	for i := range d.palette {
+		d.palette[i] = color.RGBA{A: 255}
+	}
+	copy(d.palette[:], sixelPalette[:])
+	return d
+}
+
+func (d *sixelDecoder) put(b byte) {
+	if d.cmd != 0 {
+		switch {
+		case b >= '0' && b <= '9':
+			if len(d.params) == 0 {
+				d.params = append(d.params, 0)
+			}
+			n := &d.params[len(d.params)-1]
+			if *n < 1<<16 {
+				*n = *n*10 + int(b-'0')
+			}
+			return
+		case b == ';':
+			if len(d.params) == 0 {
+				d.params = append(d.params, 0)
+			}
+			d.params = append(d.params, 0)
+			return
+		default:
+			cmd := d.cmd
+			d.finishCommand(b)
+			d.cmd = 0
+			d.params = d.params[:0]
+			if cmd == '!' && b >= '?' && b <= '~' {
+				// the repeat introducer consumes the sixel that follows it
+				return
+			}
+		}
+	}
+
+	switch {
+	case b == '#' || b == '!' || b == '"':
+		d.cmd = b
+	case b == '$':
+		d.x = 0
+	case b == '-':
+		d.x = 0
+		d.y += 6
+	case b >= '?' && b <= '~':
+		d.sixel(b, 1)
+	}
+}
+
+// finishCommand applies the pending control command now that its parameters
+// have been read. b is the byte that terminated the parameters.
+func (d *sixelDecoder) finishCommand(b byte) {
+	param := func(i, def int) int {
+		if i < len(d.params) {
+			return d.params[i]
+		}
+		return def
+	}
+
+	switch d.cmd {
+	case '"':
+		// raster attributes: Pan; Pad; Ph; Pv
+		w, h := min(param(2, 0), maxSixelSize), min(param(3, 0), maxSixelSize)
+		if w > d.width {
+			d.width = w
+		}
+		if h > d.height {
+			d.height = h
+		}
+	case '#':
+		reg := uint8(param(0, 0))
+		if len(d.params) >= 5 {
+			x, y, z := param(2, 0), param(3, 0), param(4, 0)
+			switch param(1, 0) {
+			case 1: // HLS, with hue rotated so that 0 degrees is blue
+				c := colorful.Hsl(float64((x+240)%360), float64(min(z, 100))/100, float64(min(y, 100))/100)
+				r, g, b := c.RGB255()
+				d.palette[reg] = color.RGBA{r, g, b, 255}
+			case 2: // RGB percentages
+				d.palette[reg] = color.RGBA{
+					uint8(min(x, 100) * 255 / 100),
+					uint8(min(y, 100) * 255 / 100),
+					uint8(min(z, 100) * 255 / 100),
+					255,
+				}
+			}
+		}
+		d.color = reg
+	case '!':
+		if b >= '?' && b <= '~' {
+			d.sixel(b, max(param(0, 1), 1))
+		}
+	}
+}
+
+// sixel draws a column of up to six pixels, repeated n times.
+func (d *sixelDecoder) sixel(b byte, n int) {
+	bits := b - '?'
+	if bits != 0 {
+		right, bottom := min(d.x+n, maxSixelSize), d.y+6
+		for bottom > d.y && bits&(1<<(bottom-d.y-1)) == 0 {
+			bottom--
+		}
+		bottom = min(bottom, maxSixelSize)
+		d.grow(right, bottom)
+		c := d.palette[d.color]
+		for i := 0; i < 6; i++ {
+			if bits&(1<<i) == 0 || d.y+i >= maxSixelSize {
+				continue
+			}
+			for x := d.x; x < right; x++ {
+				d.pix.SetRGBA(x, d.y+i, c)
+			}
+		}
+		if right > d.width {
+			d.width = right
+		}
+		if bottom > d.height {
+			d.height = bottom
+		}
+	}
+	d.x += n
+}
+
+// grow ensures the pixel buffer covers at least w x h pixels.
+func (d *sixelDecoder) grow(w, h int) {
+	bounds := d.pix.Bounds()
+	if w <= bounds.Dx() && h <= bounds.Dy() {
+		return
+	}
+	nw, nh := bounds.Dx(), bounds.Dy()
+	for nw < w {
+		nw = max(nw*2, 64)
+	}
+	for nh < h {
+		nh = max(nh*2, 64)
+	}
+	pix := image.NewRGBA(image.Rect(0, 0, min(nw, maxSixelSize), min(nh, maxSixelSize)))
+	for y := 0; y < bounds.Dy(); y++ {
+		copy(pix.Pix[y*pix.Stride:], d.pix.Pix[y*d.pix.Stride:(y+1)*d.pix.Stride])
+	}
+	d.pix = pix
+}
+
+// image returns the decoded image, or nil if nothing was drawn.
+func (d *sixelDecoder) image() image.Image {
+	if d.cmd != 0 {
+		d.finishCommand(0)
+		d.cmd = 0
+	}
+	if d.width == 0 || d.height == 0 {
+		return nil
+	}
+	img := image.NewRGBA(image.Rect(0, 0, d.width, d.height))
+	if !d.transparent {
+		bg := d.palette[0]
+		for i := 0; i < len(img.Pix); i += 4 {
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = bg.R, bg.G, bg.B, bg.A
+		}
+	}
+	bounds := d.pix.Bounds()
+	for y := 0; y < min(d.height, bounds.Dy()); y++ {
+		src := d.pix.Pix[y*d.pix.Stride : y*d.pix.Stride+min(d.width, bounds.Dx())*4]
+		dst := img.Pix[y*img.Stride:]
+		for i := 0; i < len(src); i += 4 {
+			if src[i+3] != 0 {
+				copy(dst[i:i+4], src[i:i+4])
+			}
+		}
+	}
+	return img
+}
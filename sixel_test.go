@@ -0,0 +1,107 @@
+package midterm_test
+
+import (
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vito/midterm"
+)
+
+// redSixel is a 4x12 pixel red block: two bands of four full sixels.
+const redSixel = "\x1bPq#1;2;100;0;0#1~~~~-~~~~\x1b\\"
+
+func TestSixel(t *testing.T) {
+	t.Run("decodes and places the image at the cursor", func(t *testing.T) {
+		vt := midterm.NewTerminal(5, 20)
+		mustFprintf(t, vt, "ab"+redSixel)
+
+		images := vt.Images()
+		require.Len(t, images, 1)
+		img := images[0]
+		require.Equal(t, midterm.ImageSixel, img.Protocol)
+		require.Equal(t, 0, img.Row)
+		require.Equal(t, 2, img.Col)
+		require.Equal(t, 1, img.Rows)
+		require.Equal(t, 1, img.Cols)
+		require.Equal(t, 4, img.Image.Bounds().Dx())
+		require.Equal(t, 12, img.Image.Bounds().Dy())
+		require.Equal(t, color.RGBA{255, 0, 0, 255}, img.Image.At(3, 11))
+	})
+
+	t.Run("honors repeats and raster attributes", func(t *testing.T) {
+		vt := midterm.NewTerminal(5, 20)
+		vt.CellWidth, vt.CellHeight = 4, 4
+		mustFprintf(t, vt, "\x1bP0;1q\"1;1;30;9#2!30~\x1b\\")
+
+		images := vt.Images()
+		require.Len(t, images, 1)
+		img := images[0]
+		require.Equal(t, 30, img.Image.Bounds().Dx())
+		require.Equal(t, 9, img.Image.Bounds().Dy())
+		require.Equal(t, 3, img.Rows)
+		require.Equal(t, 8, img.Cols)
+		// transparent background below the drawn band
+		_, _, _, a := img.Image.At(0, 8).RGBA()
+		require.Zero(t, a)
+
+		// the cursor is left on the image's last row, in its first column
+		require.Equal(t, 2, vt.Cursor.Y)
+		require.Equal(t, 0, vt.Cursor.X)
+	})
+
+	t.Run("scrolls with the content", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 20)
+		mustFprintf(t, vt, "\r\n"+redSixel+"\r\n\r\n")
+
+		images := vt.Images()
+		require.Len(t, images, 1)
+		require.Equal(t, 0, images[0].Row)
+
+		mustFprintf(t, vt, "\r\n")
+		require.Empty(t, vt.Images())
+	})
+
+	t.Run("scrolls a fixed-size screen instead of growing it", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 20)
+		vt.CellWidth, vt.CellHeight = 4, 4
+		tall := "\x1bPq\"1;1;4;24#1~~~~-~~~~-~~~~-~~~~\x1b\\"
+		mustFprintf(t, vt, "\r\n"+tall)
+
+		require.Equal(t, 3, vt.Height)
+		require.Len(t, vt.Content, 3)
+		require.Equal(t, 2, vt.Cursor.Y)
+		images := vt.Images()
+		require.Len(t, images, 1)
+		require.Equal(t, -3, images[0].Row)
+		require.Equal(t, 6, images[0].Rows)
+
+		vt = midterm.NewTerminal(3, 20)
+		vt.AutoResizeY = true
+		vt.CellWidth, vt.CellHeight = 4, 4
+		mustFprintf(t, vt, "\r\n"+tall)
+		require.Equal(t, 7, vt.Height)
+		require.Equal(t, 6, vt.Cursor.Y)
+		require.Equal(t, 1, vt.Images()[0].Row)
+	})
+
+	t.Run("is overwritten by text", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 20)
+		mustFprintf(t, vt, redSixel)
+		require.Len(t, vt.Images(), 1)
+
+		mustFprintf(t, vt, "x")
+		require.Empty(t, vt.Images())
+	})
+
+	t.Run("is rendered into HTML", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 20)
+		mustFprintf(t, vt, redSixel)
+
+		html := vt.HTML()
+		require.True(t, strings.HasPrefix(html, `<pre style="color:white;background-color:black;overflow:hidden;position:relative;">`))
+		require.Contains(t, html, `<img src="data:image/png;base64,`)
+		require.Contains(t, html, `left:0ch;top:0lh;width:1ch;height:1lh;`)
+	})
+}
@@ -0,0 +1,314 @@
+package midterm
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/muesli/termenv"
+)
+
+// ScrollbackStore stores the lines evicted from a Scrollback's memory.
+type ScrollbackStore interface {
+	// Append adds lines to the end of the store.
+	Append(lines ...Line) error
+
+	// Len returns the number of lines in the store.
+	Len() int
+
+	// Line returns the i'th line in the store, counting from the oldest.
+	Line(i int) (Line, error)
+
+	// Clear removes all lines from the store.
+	Clear() error
+}
+
+// fileStoreChunkLines is the number of lines compressed together in a chunk.
+const fileStoreChunkLines = 256
+
+// FileStore is a ScrollbackStore which appends lines to a file in compressed
+// chunks, keeping only an index of the chunks in memory. Lines are buffered in
+// memory until there are enough of them to fill a chunk.
+//
+// Each chunk is written as a 4-byte big-endian length followed by the
+// zlib-compressed JSON encoding of its lines.
+type FileStore struct {
+	file *os.File
+
+	// chunks indexes the chunks written to the file.
+	chunks []fileChunk
+
+	// end is the offset at which the next chunk will be written.
+	end int64
+
+	// pending are the lines not yet written.
+	pending []Line
+
+	// cached is the most recently read chunk, since lines tend to be read in
+	// order.
+	cached      int
+	cachedLines []Line
+}
+
+type fileChunk struct {
+	// first is the index of the first line in the chunk.
+	first int
+
+	// offset and size locate the compressed chunk in the file.
+	offset int64
+	size   int
+}
+
+var _ ScrollbackStore = (*FileStore)(nil)
+
+// NewFileStore creates a FileStore which writes to the file at path,
+// truncating it if it already exists.
+func NewFileStore(path string) (*FileStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileStore{file: file, cached: -1}, nil
+}
+
+// Len returns the number of lines in the store.
+func (s *FileStore) Len() int {
+	return s.written() + len(s.pending)
+}
+
+// written returns the number of lines written to the file.
+func (s *FileStore) written() int {
+	return len(s.chunks) * fileStoreChunkLines
+}
+
+// Append adds lines to the end of the store, writing out full chunks.
+func (s *FileStore) Append(lines ...Line) error {
+	s.pending = append(s.pending, lines...)
+	for len(s.pending) >= fileStoreChunkLines {
+		if err := s.writeChunk(s.pending[:fileStoreChunkLines]); err != nil {
+			return err
+		}
+		s.pending = append(s.pending[:0], s.pending[fileStoreChunkLines:]...)
+	}
+	return nil
+}
+
+// Line returns the i'th line in the store, reading its chunk from the file if
+// need be.
+func (s *FileStore) Line(i int) (Line, error) {
+	if i < 0 || i >= s.Len() {
+		return Line{}, fmt.Errorf("line %d out of range", i)
+	}
+	if written := s.written(); i >= written {
+		return s.pending[i-written], nil
+	}
+	chunk := sort.Search(len(s.chunks), func(c int) bool {
+		return s.chunks[c].first > i
+	}) - 1
+	if chunk != s.cached {
+		lines, err := s.readChunk(s.chunks[chunk])
+		if err != nil {
+			return Line{}, err
+		}
+		s.cached, s.cachedLines = chunk, lines
+	}
+	return s.cachedLines[i-s.chunks[chunk].first], nil
+}
+
+// Clear removes all lines from the store, truncating the file.
+func (s *FileStore) Clear() error {
+	s.chunks = nil
+	s.end = 0
+	s.pending = nil
+	s.cached, s.cachedLines = -1, nil
+	return s.file.Truncate(0)
+}
+
+// Close closes the file. The store must not be used afterwards.
+func (s *FileStore) Close() error {
+	return s.file.Close()
+}
+
+func (s *FileStore) writeChunk(lines []Line) error {
+	var buf bytes.Buffer
+	buf.Write(make([]byte, 4)) // length, filled in below
+	zw := zlib.NewWriter(&buf)
+	enc := json.NewEncoder(zw)
+	for _, line := range lines {
+		if err := enc.Encode(encodeLine(line)); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data, uint32(len(data)-4))
+	if _, err := s.file.WriteAt(data, s.end); err != nil {
+		return err
+	}
+	s.chunks = append(s.chunks, fileChunk{
+		first:  s.written(),
+		offset: s.end + 4,
+		size:   len(data) - 4,
+	})
+	s.end += int64(len(data))
+	return nil
+}
+
+func (s *FileStore) readChunk(chunk fileChunk) ([]Line, error) {
+	data := make([]byte, chunk.size)
+	if _, err := s.file.ReadAt(data, chunk.offset); err != nil {
+		return nil, err
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	dec := json.NewDecoder(zr)
+	lines := make([]Line, 0, fileStoreChunkLines)
+	for {
+		var stored storedLine
+		if err := dec.Decode(&stored); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		line, err := stored.decode()
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != fileStoreChunkLines {
+		return nil, fmt.Errorf("chunk at %d has %d lines", chunk.offset, len(lines))
+	}
+	return lines, nil
+}
+
+// storedLine is the encoding of a Line in a FileStore.
+type storedLine struct {
+	Text    string            `json:"t"`
+	Runs    []storedRun       `json:"r,omitempty"`
+	Wrapped bool              `json:"w,omitempty"`
+	Written int64             `json:"at,omitempty"`
+	Tags    map[string]string `json:"tags,omitempty"`
+}
+
+// storedRun is a run of cells sharing a format.
+type storedRun struct {
+	Fg         string `json:"f,omitempty"`
+	Bg         string `json:"b,omitempty"`
+	Properties uint8  `json:"p,omitempty"`
+	Size       int    `json:"n"`
+}
+
+func encodeLine(line Line) storedLine {
+	stored := storedLine{
+		Text:    string(line.Content),
+		Wrapped: line.Info.Wrapped,
+		Tags:    line.Info.Tags,
+	}
+	if !line.Info.Written.IsZero() {
+		stored.Written = line.Info.Written.UnixNano()
+	}
+	for col := range line.Content {
+		var f Format
+		if col < len(line.Format) {
+			f = line.Format[col]
+		}
+		run := storedRun{
+			Fg:         encodeColor(f.Fg),
+			Bg:         encodeColor(f.Bg),
+			Properties: f.Properties,
+			Size:       1,
+		}
+		if last := len(stored.Runs) - 1; last >= 0 {
+			if prev := &stored.Runs[last]; prev.Fg == run.Fg && prev.Bg == run.Bg && prev.Properties == run.Properties {
+				prev.Size++
+				continue
+			}
+		}
+		stored.Runs = append(stored.Runs, run)
+	}
+	return stored
+}
+
+func (stored storedLine) decode() (Line, error) {
+	line := Line{
+		Content: []rune(stored.Text),
+		Info: LineInfo{
+			Wrapped: stored.Wrapped,
+			Tags:    stored.Tags,
+		},
+	}
+	if stored.Written != 0 {
+		line.Info.Written = time.Unix(0, stored.Written)
+	}
+	line.Format = make([]Format, 0, len(line.Content))
+	for _, run := range stored.Runs {
+		fg, err := decodeColor(run.Fg)
+		if err != nil {
+			return Line{}, err
+		}
+		bg, err := decodeColor(run.Bg)
+		if err != nil {
+			return Line{}, err
+		}
+		f := Format{Fg: fg, Bg: bg, Properties: run.Properties}
+		for range run.Size {
+			line.Format = append(line.Format, f)
+		}
+	}
+	if len(line.Format) != len(line.Content) {
+		return Line{}, fmt.Errorf("line has %d cells but %d formats", len(line.Content), len(line.Format))
+	}
+	return line, nil
+}
+
+// encodeColor encodes a color as #rrggbb for true color, or a for the ANSI
+// colors and i for the 256 colors followed by the index.
+func encodeColor(c termenv.Color) string {
+	switch c := c.(type) {
+	case nil:
+		return ""
+	case termenv.ANSIColor:
+		return "a" + strconv.Itoa(int(c))
+	case termenv.ANSI256Color:
+		return "i" + strconv.Itoa(int(c))
+	case termenv.RGBColor:
+		return string(c)
+	default:
+		dbg.Printf("FileStore: unknown color type %T\n", c)
+		return ""
+	}
+}
+
+func decodeColor(s string) (termenv.Color, error) {
+	if s == "" {
+		return nil, nil
+	}
+	switch s[0] {
+	case '#':
+		return termenv.RGBColor(s), nil
+	case 'a', 'i':
+		n, err := strconv.Atoi(s[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid color %q", s)
+		}
+		if s[0] == 'a' {
+			return termenv.ANSIColor(n), nil
+		}
+		return termenv.ANSI256Color(n), nil
+	default:
+		return nil, fmt.Errorf("invalid color %q", s)
+	}
+}
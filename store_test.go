@@ -0,0 +1,116 @@
+package midterm_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/muesli/termenv"
+	"github.com/stretchr/testify/require"
+	"github.com/vito/midterm"
+)
+
+func TestFileStore(t *testing.T) {
+	t.Run("round-trips lines through compressed chunks", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "scrollback")
+		store, err := midterm.NewFileStore(path)
+		require.NoError(t, err)
+		defer store.Close()
+
+		bold := midterm.Format{Fg: termenv.RGBColor("#ff8000"), Properties: midterm.BoldBit}
+		faint := midterm.Format{Bg: termenv.ANSI256Color(200), Properties: midterm.FaintBit}
+		red := midterm.Format{Fg: termenv.ANSIRed}
+		written := time.Unix(1700000000, 12345)
+
+		line := func(i int) midterm.Line {
+			content := []rune(fmt.Sprintf("line %04d ✓ %s", i, strings.Repeat("x", 40)))
+			format := make([]midterm.Format, len(content))
+			for col := range format {
+				switch {
+				case col < 4:
+					format[col] = bold
+				case col < 10:
+					format[col] = faint
+				case col == 10:
+					format[col] = red
+				}
+			}
+			return midterm.Line{
+				Content: content,
+				Format:  format,
+				Info: midterm.LineInfo{
+					Wrapped: i%2 == 0,
+					Written: written,
+					Tags:    map[string]string{"n": fmt.Sprint(i)},
+				},
+			}
+		}
+
+		for i := 0; i < 1000; i += 100 {
+			var batch []midterm.Line
+			for j := i; j < i+100; j++ {
+				batch = append(batch, line(j))
+			}
+			require.NoError(t, store.Append(batch...))
+		}
+		require.Equal(t, 1000, store.Len())
+
+		// read out of order, across chunks and the unwritten tail
+		for _, i := range []int{999, 0, 513, 255, 256, 768, 1} {
+			got, err := store.Line(i)
+			require.NoError(t, err)
+			expected := line(i)
+			require.Equal(t, expected.Content, got.Content)
+			require.Equal(t, expected.Format, got.Format)
+			require.Equal(t, expected.Info.Wrapped, got.Info.Wrapped)
+			require.True(t, expected.Info.Written.Equal(got.Info.Written))
+			require.Equal(t, expected.Info.Tags, got.Info.Tags)
+		}
+		_, err = store.Line(1000)
+		require.Error(t, err)
+
+		// the repetitive lines compress well
+		stat, err := os.Stat(path)
+		require.NoError(t, err)
+		require.NotZero(t, stat.Size())
+		require.Less(t, stat.Size(), int64(768*len(string(line(0).Content))/4))
+
+		require.NoError(t, store.Clear())
+		require.Zero(t, store.Len())
+		stat, err = os.Stat(path)
+		require.NoError(t, err)
+		require.Zero(t, stat.Size())
+	})
+
+	t.Run("holds lines spilled from a scrollback", func(t *testing.T) {
+		store, err := midterm.NewFileStore(filepath.Join(t.TempDir(), "scrollback"))
+		require.NoError(t, err)
+		defer store.Close()
+
+		vt := midterm.NewTerminal(2, 20)
+		vt.Scrollback = &midterm.Scrollback{MaxLines: 10, Store: store}
+		for i := range 1000 {
+			mustFprintf(t, vt, "\r\n\x1b[1mline\x1b[0m %d", i)
+		}
+
+		// the first line is blank, and the last two are on the screen
+		require.Equal(t, 999, vt.Scrollback.Len())
+		require.Equal(t, 989, store.Len())
+		require.Empty(t, string(vt.Scrollback.Line(0).Content))
+		require.Equal(t, "line 0", string(vt.Scrollback.Line(1).Content))
+		require.Equal(t, "line 500", string(vt.Scrollback.Line(501).Content))
+		require.Equal(t, "line 997", string(vt.Scrollback.Line(998).Content))
+
+		var buf strings.Builder
+		require.NoError(t, vt.Scrollback.Render(&buf, 1))
+		require.Equal(t, "\x1b[1mline\x1b[0m 0\x1b[0m", buf.String())
+		require.NoError(t, vt.Scrollback.Err())
+
+		mustFprintf(t, vt, "\x1b[3J")
+		require.Zero(t, vt.Scrollback.Len())
+		require.Zero(t, store.Len())
+	})
+}
@@ -0,0 +1,151 @@
+package teaterm
+
+import (
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/vito/midterm"
+)
+
+// keySeqs are the sequences sent for special keys. A leading CSI is replaced
+// with SS3 for the cursor keys in application mode.
+var keySeqs = map[tea.KeyType]string{
+	tea.KeyUp:             "\x1b[A",
+	tea.KeyDown:           "\x1b[B",
+	tea.KeyRight:          "\x1b[C",
+	tea.KeyLeft:           "\x1b[D",
+	tea.KeyHome:           "\x1b[H",
+	tea.KeyEnd:            "\x1b[F",
+	tea.KeyShiftTab:       "\x1b[Z",
+	tea.KeyInsert:         "\x1b[2~",
+	tea.KeyDelete:         "\x1b[3~",
+	tea.KeyPgUp:           "\x1b[5~",
+	tea.KeyPgDown:         "\x1b[6~",
+	tea.KeyCtrlPgUp:       "\x1b[5;5~",
+	tea.KeyCtrlPgDown:     "\x1b[6;5~",
+	tea.KeySpace:          " ",
+	tea.KeyCtrlUp:         "\x1b[1;5A",
+	tea.KeyCtrlDown:       "\x1b[1;5B",
+	tea.KeyCtrlRight:      "\x1b[1;5C",
+	tea.KeyCtrlLeft:       "\x1b[1;5D",
+	tea.KeyCtrlHome:       "\x1b[1;5H",
+	tea.KeyCtrlEnd:        "\x1b[1;5F",
+	tea.KeyShiftUp:        "\x1b[1;2A",
+	tea.KeyShiftDown:      "\x1b[1;2B",
+	tea.KeyShiftRight:     "\x1b[1;2C",
+	tea.KeyShiftLeft:      "\x1b[1;2D",
+	tea.KeyShiftHome:      "\x1b[1;2H",
+	tea.KeyShiftEnd:       "\x1b[1;2F",
+	tea.KeyCtrlShiftUp:    "\x1b[1;6A",
+	tea.KeyCtrlShiftDown:  "\x1b[1;6B",
+	tea.KeyCtrlShiftRight: "\x1b[1;6C",
+	tea.KeyCtrlShiftLeft:  "\x1b[1;6D",
+	tea.KeyCtrlShiftHome:  "\x1b[1;6H",
+	tea.KeyCtrlShiftEnd:   "\x1b[1;6F",
+	tea.KeyF1:             "\x1bOP",
+	tea.KeyF2:             "\x1bOQ",
+	tea.KeyF3:             "\x1bOR",
+	tea.KeyF4:             "\x1bOS",
+	tea.KeyF5:             "\x1b[15~",
+	tea.KeyF6:             "\x1b[17~",
+	tea.KeyF7:             "\x1b[18~",
+	tea.KeyF8:             "\x1b[19~",
+	tea.KeyF9:             "\x1b[20~",
+	tea.KeyF10:            "\x1b[21~",
+	tea.KeyF11:            "\x1b[23~",
+	tea.KeyF12:            "\x1b[24~",
+	tea.KeyF13:            "\x1b[1;2P",
+	tea.KeyF14:            "\x1b[1;2Q",
+	tea.KeyF15:            "\x1b[1;2R",
+	tea.KeyF16:            "\x1b[1;2S",
+	tea.KeyF17:            "\x1b[15;2~",
+	tea.KeyF18:            "\x1b[17;2~",
+	tea.KeyF19:            "\x1b[18;2~",
+	tea.KeyF20:            "\x1b[19;2~",
+}
+
+// EncodeKey returns the bytes a program with the given modes expects for a
+// key, or nil if it has no encoding.
+func EncodeKey(key tea.KeyMsg, modes midterm.InputModes) []byte {
+	var seq string
+	switch {
+	case key.Type == tea.KeyRunes:
+		seq = string(key.Runes)
+	case key.Type >= 0 && key.Type <= 31 || key.Type == 127:
+		// control characters, including enter, tab, escape and backspace
+		seq = string(rune(key.Type))
+	default:
+		var ok bool
+		seq, ok = keySeqs[key.Type]
+		if !ok {
+			return nil
+		}
+		if modes.AppCursorKeys {
+			switch key.Type {
+			case tea.KeyUp, tea.KeyDown, tea.KeyRight, tea.KeyLeft, tea.KeyHome, tea.KeyEnd:
+				seq = "\x1bO" + seq[len(seq)-1:]
+			}
+		}
+	}
+	if key.Alt {
+		seq = "\x1b" + seq
+	}
+	return []byte(seq)
+}
+
+// mouseButtons are the button codes of mouse events.
+var mouseButtons = map[tea.MouseEventType]int{
+	tea.MouseLeft:      0,
+	tea.MouseMiddle:    1,
+	tea.MouseRight:     2,
+	tea.MouseRelease:   3,
+	tea.MouseWheelUp:   64,
+	tea.MouseWheelDown: 65,
+}
+
+// EncodeMouse returns the bytes a program with the given modes expects for a
+// mouse event at a cell of the screen, or nil if it isn't to be reported.
+//
+// held is the button held during motion, as the event doesn't say, or
+// tea.MouseRelease if none is.
+func EncodeMouse(mouse tea.MouseMsg, held tea.MouseEventType, modes midterm.InputModes) []byte {
+	button, ok := mouseButtons[mouse.Type]
+	switch {
+	case mouse.Type == tea.MouseMotion:
+		if modes.Mouse < midterm.MouseTrackingDrag ||
+			held == tea.MouseRelease && modes.Mouse < midterm.MouseTrackingMotion {
+			return nil
+		}
+		button = mouseButtons[held] + 32
+	case !ok || modes.Mouse == midterm.MouseTrackingOff:
+		return nil
+	}
+	if mouse.Alt {
+		button += 8
+	}
+	if mouse.Ctrl {
+		button += 16
+	}
+
+	if modes.SGRMouse {
+		final := 'M'
+		if mouse.Type == tea.MouseRelease {
+			// SGR reports which button was released
+			final = 'm'
+			button += mouseButtons[held] - 3
+		}
+		return fmt.Appendf(nil, "\x1b[<%d;%d;%d%c", button, mouse.X+1, mouse.Y+1, final)
+	}
+	// the legacy encoding can't report cells past 223
+	x, y := min(mouse.X+1, 223), min(mouse.Y+1, 223)
+	return []byte{'\x1b', '[', 'M', byte(32 + button), byte(32 + x), byte(32 + y)}
+}
+
+// EncodePaste returns the bytes a program with the given modes expects for
+// pasted text.
+func EncodePaste(text string, modes midterm.InputModes) []byte {
+	if modes.BracketedPaste {
+		return []byte("\x1b[200~" + text + "\x1b[201~")
+	}
+	return []byte(text)
+}
@@ -0,0 +1,473 @@
+// Package teaterm provides a Bubble Tea model showing a midterm Terminal, with
+// a scrollable view of its history, input forwarding, search and copy mode.
+package teaterm
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/vito/midterm"
+)
+
+// OutputMsg is output from the program, to be written to the terminal.
+type OutputMsg []byte
+
+// ErrMsg reports a failure to write to the terminal or to the program.
+type ErrMsg struct {
+	Err error
+}
+
+// KeyMap are the keys the model handles itself rather than forwarding to the
+// program, as given by tea.KeyMsg.String.
+type KeyMap struct {
+	// Search starts searching the history.
+	Search []string
+
+	// CopyMode starts moving around the history to select and copy text.
+	CopyMode []string
+
+	// PageUp and PageDown scroll through the history.
+	PageUp, PageDown []string
+}
+
+// DefaultKeyMap is the KeyMap of a new Model.
+var DefaultKeyMap = KeyMap{
+	Search:   []string{"alt+/"},
+	CopyMode: []string{"alt+c"},
+	PageUp:   []string{"ctrl+pgup"},
+	PageDown: []string{"ctrl+pgdown"},
+}
+
+// mode is what the model does with keys.
+type mode int
+
+const (
+	// modeNormal forwards keys to the program.
+	modeNormal mode = iota
+	// modeSearch edits the search query.
+	modeSearch
+	// modeCopy moves a cursor around the history to select text.
+	modeCopy
+)
+
+// wheelRows is the number of rows scrolled by the mouse wheel.
+const wheelRows = 3
+
+// Model is a Bubble Tea model showing a Terminal.
+//
+// Keys and mouse events are forwarded to the program, encoded as it asked,
+// unless they scroll the history or are bound in the KeyMap. In copy mode,
+// h/j/k/l or the arrow keys move the cursor, v, V and ctrl+v select
+// characters, lines or a block, y or enter copy the selection, / searches,
+// n and N go to the next or previous match, and q or escape return.
+type Model struct {
+	// VT is the terminal shown.
+	VT *midterm.Terminal
+
+	// Input receives the encoded keys, mouse events and pastes for the
+	// program, e.g. a pty. If it is nil, nothing is forwarded.
+	Input io.Writer
+
+	// KeyMap are the keys handled by the model.
+	KeyMap KeyMap
+
+	// SearchOptions configure how the history is searched.
+	SearchOptions midterm.SearchOptions
+
+	// OnCopy is called with the text copied in copy mode.
+	OnCopy func(text string)
+
+	viewport *midterm.Viewport
+	mode     mode
+
+	// held is the mouse button being held, or tea.MouseRelease.
+	held tea.MouseEventType
+
+	// query is the search query, current the index of the current match,
+	// and searchErr the error compiling the query, if any.
+	query     string
+	current   int
+	searchErr error
+
+	// row and col are the copy mode cursor, in rows of the viewport, and
+	// selecting indicates that the cursor extends a selection.
+	row, col  int
+	selecting bool
+
+	// searchFrom is the mode search was started from.
+	searchFrom mode
+}
+
+// New returns a Model showing vt, forwarding input to w.
+func New(vt *midterm.Terminal, w io.Writer) *Model {
+	return &Model{
+		VT:            vt,
+		Input:         w,
+		KeyMap:        DefaultKeyMap,
+		SearchOptions: midterm.SearchOptions{SmartCase: true, Scrollback: true},
+		viewport:      vt.NewViewport(vt.Height),
+		held:          tea.MouseRelease,
+	}
+}
+
+// Init implements tea.Model.
+func (m *Model) Init() tea.Cmd {
+	return nil
+}
+
+// Update implements tea.Model.
+func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case OutputMsg:
+		if _, err := m.VT.Write(msg); err != nil {
+			return m, errCmd(err)
+		}
+		if m.query != "" {
+			m.search()
+		}
+	case tea.WindowSizeMsg:
+		m.VT.Resize(msg.Height, msg.Width)
+		m.viewport.Height = msg.Height
+		m.row, m.col = min(m.row, m.rows()-1), min(m.col, msg.Width-1)
+	case tea.KeyMsg:
+		switch m.mode {
+		case modeSearch:
+			m.searchKey(msg)
+		case modeCopy:
+			m.copyKey(msg)
+		default:
+			return m, m.normalKey(msg)
+		}
+	case tea.MouseMsg:
+		return m, m.mouse(msg)
+	}
+	return m, nil
+}
+
+// View implements tea.Model.
+func (m *Model) View() string {
+	var buf strings.Builder
+	for row := range m.rows() {
+		if row > 0 {
+			buf.WriteString("\n")
+		}
+		if err := m.viewport.RenderLine(&buf, row); err != nil {
+			return err.Error()
+		}
+	}
+	if status := m.status(); status != "" {
+		buf.WriteString("\n\x1b[7m")
+		buf.WriteString(status)
+		buf.WriteString("\x1b[0m")
+	}
+	return buf.String()
+}
+
+// Paste sends pasted text to the program.
+func (m *Model) Paste(text string) tea.Cmd {
+	return m.send(EncodePaste(text, m.VT.Modes))
+}
+
+// rows returns the number of rows of the viewport shown, leaving room for the
+// status line if there is one.
+func (m *Model) rows() int {
+	if m.mode != modeNormal {
+		return max(m.viewport.Height-1, 0)
+	}
+	return m.viewport.Height
+}
+
+// status returns the status line, padded to the width of the terminal, or ""
+// if there is none.
+func (m *Model) status() string {
+	var status string
+	switch m.mode {
+	case modeSearch:
+		status = "search: " + m.query
+		if m.searchErr != nil {
+			status += " (invalid)"
+		}
+	case modeCopy:
+		status = "[copy]"
+	default:
+		return ""
+	}
+	if count := m.VT.SearchMatchCount(); m.query != "" && count > 0 {
+		status += fmt.Sprintf(" [%d/%d]", m.current+1, count)
+	}
+	width := m.VT.Width
+	if n := utf8.RuneCountInString(status); n < width {
+		status += strings.Repeat(" ", width-n)
+	} else {
+		status = string([]rune(status)[:width])
+	}
+	return status
+}
+
+// normalKey forwards a key to the program, unless it is bound.
+func (m *Model) normalKey(key tea.KeyMsg) tea.Cmd {
+	switch k := key.String(); {
+	case slices.Contains(m.KeyMap.Search, k):
+		m.startSearch()
+	case slices.Contains(m.KeyMap.CopyMode, k):
+		m.startCopy()
+	case slices.Contains(m.KeyMap.PageUp, k):
+		m.viewport.ScrollUp(m.viewport.Height)
+	case slices.Contains(m.KeyMap.PageDown, k):
+		m.viewport.ScrollDown(m.viewport.Height)
+	default:
+		// typing returns to the bottom, where the program is
+		m.viewport.ScrollToBottom()
+		return m.send(EncodeKey(key, m.VT.Modes))
+	}
+	return nil
+}
+
+// mouse forwards a mouse event to the program if it asked for them and the
+// viewport is at the bottom, or scrolls the history with the wheel.
+func (m *Model) mouse(mouse tea.MouseMsg) tea.Cmd {
+	if m.mode == modeNormal && m.VT.Modes.Mouse != midterm.MouseTrackingOff && m.viewport.Following() {
+		data := EncodeMouse(mouse, m.held, m.VT.Modes)
+		switch mouse.Type {
+		case tea.MouseLeft, tea.MouseMiddle, tea.MouseRight:
+			m.held = mouse.Type
+		case tea.MouseRelease:
+			m.held = tea.MouseRelease
+		}
+		return m.send(data)
+	}
+	switch mouse.Type {
+	case tea.MouseWheelUp:
+		m.viewport.ScrollUp(wheelRows)
+	case tea.MouseWheelDown:
+		m.viewport.ScrollDown(wheelRows)
+	}
+	if m.mode == modeCopy {
+		m.moveCursor(0, 0)
+	}
+	return nil
+}
+
+// send writes data to the program.
+func (m *Model) send(data []byte) tea.Cmd {
+	if m.Input == nil || len(data) == 0 {
+		return nil
+	}
+	if _, err := m.Input.Write(data); err != nil {
+		return errCmd(err)
+	}
+	return nil
+}
+
+func errCmd(err error) tea.Cmd {
+	return func() tea.Msg {
+		return ErrMsg{Err: err}
+	}
+}
+
+// startSearch starts editing the search query.
+func (m *Model) startSearch() {
+	m.searchFrom = m.mode
+	m.mode = modeSearch
+	m.query = ""
+	m.searchErr = nil
+	m.VT.SearchClear()
+}
+
+// searchKey edits the search query.
+func (m *Model) searchKey(key tea.KeyMsg) {
+	switch key.Type {
+	case tea.KeyEscape, tea.KeyCtrlC:
+		m.query = ""
+		m.VT.SearchClear()
+		if m.mode = m.searchFrom; m.mode == modeNormal {
+			m.viewport.ScrollToBottom()
+		}
+		return
+	case tea.KeyEnter:
+		if m.searchFrom == modeNormal {
+			m.startCopy()
+		} else {
+			m.mode = modeCopy
+		}
+		m.showMatch()
+		return
+	case tea.KeyBackspace:
+		if m.query != "" {
+			_, size := utf8.DecodeLastRuneInString(m.query)
+			m.query = m.query[:len(m.query)-size]
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		m.query += string(key.Runes)
+	default:
+		return
+	}
+	// start from the newest match, searching backwards like a shell
+	m.current = -1
+	m.search()
+	m.showMatch()
+}
+
+// search searches the history for the query again, keeping the current match.
+func (m *Model) search() {
+	count, err := m.VT.SearchWithOptions(m.query, m.SearchOptions)
+	m.searchErr = err
+	if count == 0 {
+		m.current = 0
+		return
+	}
+	if m.current < 0 || m.current >= count {
+		m.current = count - 1
+	}
+	m.VT.SearchSetCurrent(m.current)
+}
+
+// showMatch scrolls the current match into view, and puts the copy mode
+// cursor on it.
+func (m *Model) showMatch() {
+	count := m.VT.SearchMatchCount()
+	if count == 0 {
+		return
+	}
+	row, col := m.VT.SearchSetCurrent(m.current)
+	// rows of the viewport count from the oldest line of the scrollback
+	row += m.viewport.Rows() - m.VT.Height
+	if top := m.viewport.Offset(); row < top || row >= top+m.rows() {
+		m.viewport.ScrollTo(row - m.rows()/2)
+	}
+	m.row, m.col = row-m.viewport.Offset(), col
+	if m.mode == modeCopy {
+		m.moveCursor(0, 0)
+	}
+}
+
+// startCopy starts copy mode, with the cursor on the terminal's cursor.
+func (m *Model) startCopy() {
+	m.mode = modeCopy
+	m.selecting = false
+	m.row = min(max(m.VT.Cursor.Y+m.viewport.Rows()-m.VT.Height-m.viewport.Offset(), 0), m.rows()-1)
+	m.col = m.VT.Cursor.X
+	m.moveCursor(0, 0)
+}
+
+// stopCopy leaves copy mode, returning to the bottom of the history.
+func (m *Model) stopCopy() {
+	m.mode = modeNormal
+	m.selecting = false
+	m.query = ""
+	m.VT.SearchClear()
+	m.VT.ClearSelection()
+	m.viewport.ScrollToBottom()
+}
+
+// copyKey handles a key in copy mode.
+func (m *Model) copyKey(key tea.KeyMsg) {
+	page := m.rows()
+	switch key.String() {
+	case "h", "left":
+		m.moveCursor(0, -1)
+	case "j", "down":
+		m.moveCursor(1, 0)
+	case "k", "up":
+		m.moveCursor(-1, 0)
+	case "l", "right":
+		m.moveCursor(0, 1)
+	case "0", "home":
+		m.moveCursor(0, -m.col)
+	case "$", "end":
+		m.moveCursor(0, m.VT.Width-1-m.col)
+	case "ctrl+u":
+		m.moveCursor(-page/2, 0)
+	case "ctrl+d":
+		m.moveCursor(page/2, 0)
+	case "ctrl+b", "pgup":
+		m.moveCursor(-page, 0)
+	case "ctrl+f", "pgdown":
+		m.moveCursor(page, 0)
+	case "g":
+		m.viewport.ScrollToTop()
+		m.row = 0
+		m.moveCursor(0, 0)
+	case "G":
+		m.viewport.ScrollToBottom()
+		m.row = page - 1
+		m.moveCursor(0, 0)
+	case "v":
+		m.toggleSelection(midterm.SelectChar)
+	case "V":
+		m.toggleSelection(midterm.SelectLine)
+	case "ctrl+v":
+		m.toggleSelection(midterm.SelectBlock)
+	case "y", "enter":
+		if m.selecting {
+			if text := m.VT.SelectedText(); m.OnCopy != nil {
+				m.OnCopy(text)
+			}
+		}
+		m.stopCopy()
+	case "/":
+		m.startSearch()
+	case "n":
+		m.nextMatch(-1)
+	case "N":
+		m.nextMatch(1)
+	case "q", "esc", "ctrl+c":
+		m.stopCopy()
+	}
+}
+
+// nextMatch moves by delta matches, from newer to older for negative deltas,
+// wrapping around.
+func (m *Model) nextMatch(delta int) {
+	count := m.VT.SearchMatchCount()
+	if count == 0 {
+		return
+	}
+	m.current = ((m.current+delta)%count + count) % count
+	m.showMatch()
+}
+
+// toggleSelection starts selecting from the cursor in a mode, or stops
+// selecting.
+func (m *Model) toggleSelection(mode midterm.SelectionMode) {
+	if sel, ok := m.VT.Selection(); m.selecting && ok && sel.Mode == mode {
+		m.selecting = false
+		m.moveCursor(0, 0)
+		return
+	}
+	if m.selecting {
+		// switch modes, keeping the anchor
+		sel, _ := m.VT.Selection()
+		m.VT.StartSelection(sel.Anchor, mode)
+		m.VT.ExtendSelection(sel.Head)
+		return
+	}
+	m.selecting = true
+	m.VT.StartSelection(m.viewport.Position(m.row, m.col), mode)
+}
+
+// moveCursor moves the copy mode cursor, scrolling the viewport when it
+// reaches the top or bottom. Unless selecting, the cursor is shown as a
+// selection of the cell under it.
+func (m *Model) moveCursor(rows, cols int) {
+	m.col = min(max(m.col+cols, 0), m.VT.Width-1)
+	row := m.row + rows
+	if row < 0 {
+		m.viewport.ScrollUp(-row)
+		row = 0
+	} else if last := m.rows() - 1; row > last {
+		m.viewport.ScrollDown(row - last)
+		row = last
+	}
+	m.row = row
+	pos := m.viewport.Position(m.row, m.col)
+	if m.selecting {
+		m.VT.ExtendSelection(pos)
+	} else {
+		m.VT.StartSelection(pos, midterm.SelectChar)
+	}
+}
@@ -0,0 +1,137 @@
+package teaterm_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/stretchr/testify/require"
+	"github.com/vito/midterm"
+	"github.com/vito/midterm/teaterm"
+)
+
+func TestEncodeKey(t *testing.T) {
+	var modes midterm.InputModes
+	for _, example := range []struct {
+		key  tea.KeyMsg
+		want string
+	}{
+		{tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("é")}, "é"},
+		{tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("x"), Alt: true}, "\x1bx"},
+		{tea.KeyMsg{Type: tea.KeyEnter}, "\r"},
+		{tea.KeyMsg{Type: tea.KeyCtrlC}, "\x03"},
+		{tea.KeyMsg{Type: tea.KeyBackspace}, "\x7f"},
+		{tea.KeyMsg{Type: tea.KeyUp}, "\x1b[A"},
+		{tea.KeyMsg{Type: tea.KeyCtrlLeft}, "\x1b[1;5D"},
+		{tea.KeyMsg{Type: tea.KeyF5}, "\x1b[15~"},
+	} {
+		require.Equal(t, example.want, string(teaterm.EncodeKey(example.key, modes)), example.key.String())
+	}
+
+	modes.AppCursorKeys = true
+	require.Equal(t, "\x1bOA", string(teaterm.EncodeKey(tea.KeyMsg{Type: tea.KeyUp}, modes)))
+	require.Equal(t, "\x1bOH", string(teaterm.EncodeKey(tea.KeyMsg{Type: tea.KeyHome}, modes)))
+	require.Equal(t, "\x1b[3~", string(teaterm.EncodeKey(tea.KeyMsg{Type: tea.KeyDelete}, modes)))
+}
+
+func TestEncodeMouse(t *testing.T) {
+	click := tea.MouseMsg{X: 4, Y: 2, Type: tea.MouseLeft}
+	release := tea.MouseMsg{X: 4, Y: 2, Type: tea.MouseRelease}
+	motion := tea.MouseMsg{X: 5, Y: 2, Type: tea.MouseMotion}
+
+	var modes midterm.InputModes
+	require.Nil(t, teaterm.EncodeMouse(click, tea.MouseRelease, modes))
+
+	modes.Mouse = midterm.MouseTrackingClicks
+	require.Equal(t, "\x1b[M %#", string(teaterm.EncodeMouse(click, tea.MouseRelease, modes)))
+	require.Equal(t, "\x1b[M#%#", string(teaterm.EncodeMouse(release, tea.MouseLeft, modes)))
+	require.Nil(t, teaterm.EncodeMouse(motion, tea.MouseLeft, modes))
+
+	modes.SGRMouse = true
+	require.Equal(t, "\x1b[<0;5;3M", string(teaterm.EncodeMouse(click, tea.MouseRelease, modes)))
+	require.Equal(t, "\x1b[<0;5;3m", string(teaterm.EncodeMouse(release, tea.MouseLeft, modes)))
+
+	modes.Mouse = midterm.MouseTrackingDrag
+	require.Equal(t, "\x1b[<32;6;3M", string(teaterm.EncodeMouse(motion, tea.MouseLeft, modes)))
+	require.Nil(t, teaterm.EncodeMouse(motion, tea.MouseRelease, modes))
+
+	modes.Mouse = midterm.MouseTrackingMotion
+	require.Equal(t, "\x1b[<35;6;3M", string(teaterm.EncodeMouse(motion, tea.MouseRelease, modes)))
+}
+
+func TestModel(t *testing.T) {
+	t.Run("forwards input as the program asked", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 20)
+		var input bytes.Buffer
+		m := teaterm.New(vt, &input)
+
+		m.Update(teaterm.OutputMsg("\x1b[?1h\x1b[?2004h$ "))
+		m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("ls")})
+		m.Update(tea.KeyMsg{Type: tea.KeyUp})
+		m.Paste("a\nb")
+		require.Equal(t, "ls\x1bOA\x1b[200~a\nb\x1b[201~", input.String())
+	})
+
+	t.Run("resizes the terminal", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 20)
+		m := teaterm.New(vt, nil)
+		m.Update(tea.WindowSizeMsg{Width: 30, Height: 5})
+		require.Equal(t, 5, vt.Height)
+		require.Equal(t, 30, vt.Width)
+		require.Len(t, strings.Split(m.View(), "\n"), 5)
+	})
+
+	t.Run("scrolls the history with the wheel", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 20)
+		vt.Scrollback = &midterm.Scrollback{}
+		m := teaterm.New(vt, nil)
+		for i := range 10 {
+			m.Update(teaterm.OutputMsg("line " + string(rune('0'+i)) + "\r\n"))
+		}
+		require.Contains(t, m.View(), "line 9")
+
+		m.Update(tea.MouseMsg{Type: tea.MouseWheelUp})
+		view := m.View()
+		require.Contains(t, view, "line 6")
+		require.NotContains(t, view, "line 9")
+
+		// typing returns to the bottom
+		m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("x")})
+		require.Contains(t, m.View(), "line 9")
+	})
+
+	t.Run("searches and copies", func(t *testing.T) {
+		vt := midterm.NewTerminal(4, 20)
+		vt.Scrollback = &midterm.Scrollback{}
+		var copied []string
+		m := teaterm.New(vt, nil)
+		m.OnCopy = func(text string) {
+			copied = append(copied, text)
+		}
+		m.Update(teaterm.OutputMsg("error one\r\nok\r\nerror two\r\nok\r\nok\r\nok\r\n"))
+
+		m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("/"), Alt: true})
+		m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("error")})
+		require.Equal(t, 2, vt.SearchMatchCount())
+		require.Contains(t, m.View(), "search: error [2/2]")
+
+		// the newest match is current; n goes to older ones
+		m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+		m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("n")})
+		require.Contains(t, m.View(), "[copy] [1/2]")
+		require.Contains(t, m.View(), "one")
+
+		// select the word from the cursor at the start of the match
+		m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("v")})
+		for range 4 {
+			m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("l")})
+		}
+		m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("y")})
+		require.Equal(t, []string{"error"}, copied)
+		require.NotContains(t, m.View(), "[copy]")
+		require.Zero(t, vt.SearchMatchCount())
+		_, selected := vt.Selection()
+		require.False(t, selected)
+	})
+}
@@ -1,8 +1,10 @@
 package midterm
 
 import (
+	"fmt"
 	"io"
 	"sync"
+	"time"
 
 	"github.com/danielgatis/go-ansicode"
 	"github.com/muesli/termenv"
@@ -18,6 +20,9 @@ type Terminal struct {
 	// The title of the terminal
 	Title string
 
+	// Cwd is the working directory last reported by the shell via OSC 7.
+	Cwd WorkingDirectory
+
 	// Alt is either the alternate screen (if !IsAlt) or the main screen (if
 	// IsAlt).
 	Alt *Screen
@@ -39,8 +44,14 @@ type Terminal struct {
 	ForwardRequests io.Writer
 
 	// ForwardResponses is the writer to which we send responses to CSI/OSC queries.
+	// Responses are written once the Write which queried has finished
+	// processing input, so the writer may use the terminal.
 	ForwardResponses io.Writer
 
+	// Modes are the input modes set by the program, which decide how keys,
+	// mouse events and pastes should be encoded for it.
+	Modes InputModes
+
 	// Enable "raw" mode. Line endings do not imply a carriage return.
 	Raw bool
 
@@ -48,6 +59,34 @@ type Terminal struct {
 	// cause output to be lost - for example, setting a scrolling region.
 	AppendOnly bool
 
+	// Bells counts the number of times the bell has rung.
+	Bells int
+
+	// LastBell is when the bell last rang.
+	LastBell time.Time
+
+	// VisualBell makes the screen flash for VisualBellDuration whenever the
+	// bell rings, inverting its colors in Render and HTML.
+	VisualBell bool
+
+	// CellWidth and CellHeight are the size of a cell in pixels, used for
+	// determining how many cells an image covers.
+	CellWidth, CellHeight int
+
+	// ImageMemoryLimit caps the number of bytes used to store images sent via
+	// the kitty graphics protocol, past which the oldest images are evicted.
+	// If zero, DefaultImageMemoryLimit is used.
+	ImageMemoryLimit int
+
+	// kitty stores images sent via the kitty graphics protocol.
+	kitty *kittyImages
+
+	// shell tracks shell integration marks.
+	shell *shellIntegration
+
+	// lines numbers the lines of the main screen for anchoring positions.
+	lines *lineNumbers
+
 	// wrap indicates that we've reached the end of the screen and need to wrap
 	// to the next line if another character is printed.
 	wrap bool
@@ -58,15 +97,37 @@ type Terminal struct {
 
 	*ansicode.Decoder
 
+	// decoder parses the input passed to Write, which unlike the embedded
+	// Decoder also understands graphics sequences.
+	decoder *decoder
+
 	// onResize is a hook called every time the terminal resizes.
 	onResize OnResizeFunc
 
+	// Scrollback stores the lines scrolled off the top of the main screen. It
+	// is nil by default, i.e. lines scrolled off are discarded.
+	Scrollback *Scrollback
+
 	// onScrollack is a hook called every time a line is about to be pushed out
 	// of the visible screen region.
 	onScrollback OnScrollbackFunc
 
+	// onCwdChange is a hook called every time the shell reports a new working
+	// directory.
+	onCwdChange OnCwdChangeFunc
+
+	// onNotify is a hook called for each desktop notification.
+	onNotify OnNotifyFunc
+
+	// onProgress is a hook called for each progress report.
+	onProgress OnProgressFunc
+
+	// onBell is a hook called every time the bell rings.
+	onBell OnBellFunc
+
 	// SearchHighlights holds per-row highlight ranges, keyed by row index.
 	// Set by Search() or directly by the caller; consulted by renderLine.
+	// Highlights move along with their rows as the screen scrolls.
 	SearchHighlights map[int][]SearchHighlight
 
 	// SearchMatchStyle is the Format override for non-current matches.
@@ -81,8 +142,35 @@ type Terminal struct {
 	// searchCache holds state from the previous Search() for incremental updates.
 	searchCache *searchState
 
+	// WordSeparators are the characters besides whitespace which delimit words
+	// when selecting by word.
+	WordSeparators string
+
+	// SelectionStyle is the Format override for selected cells.
+	SelectionStyle Format
+
+	// selection is the current selection, if any.
+	selection *Selection
+
+	// rules are the highlight rules, if any.
+	rules *highlightRules
+
+	// RenderLinks renders the links detected on the screen which have a URL
+	// as OSC 8 hyperlinks in Render, and as anchors in HTML.
+	RenderLinks bool
+
+	// links caches the links rendered on each row, if any.
+	links *linkCache
+
 	// for synchronizing e.g. writes and async resizing
 	mut sync.Mutex
+
+	// queued holds the hook calls and responses made while locking, which
+	// are run once unlocked so that they may re-enter the terminal.
+	queued []func()
+
+	// queueing is set while hook calls and responses are queued.
+	queueing bool
 }
 
 // Cursor represents both the position and text type of the cursor.
@@ -103,6 +191,12 @@ type ScrollRegion struct {
 	Start, End int
 }
 
+// Default pixel dimensions of a cell.
+const (
+	defaultCellWidth  = 10
+	defaultCellHeight = 20
+)
+
 // NewAutoResizingTerminal creates a new Terminal object with small initial
 // dimensions, configured to automatically resize width and height as needed.
 //
@@ -122,7 +216,9 @@ func NewAutoResizingTerminal() *Terminal {
 // default.
 func NewTerminal(rows, cols int) *Terminal {
 	v := &Terminal{
-		Screen: newScreen(rows, cols),
+		Screen:     newScreen(rows, cols),
+		CellWidth:  defaultCellWidth,
+		CellHeight: defaultCellHeight,
 		SearchMatchStyle: Format{
 			Bg:         termenv.ANSIWhite,
 			Fg:         termenv.ANSIBlack,
@@ -133,18 +229,75 @@ func NewTerminal(rows, cols int) *Terminal {
 			Fg:         termenv.ANSIBlack,
 			Properties: ResetBit,
 		},
+		WordSeparators: DefaultWordSeparators,
+		SelectionStyle: Format{
+			Bg:         termenv.ANSIBlue,
+			Fg:         termenv.ANSIBrightWhite,
+			Properties: ResetBit,
+		},
 	}
 	v.Decoder = ansicode.NewDecoder(v)
+	v.decoder = newDecoder(v)
 	v.reset()
 	return v
 }
 
-// Write writes the input sequence to the terminal.
+// Write writes the input sequence to the terminal. It is safe to call
+// concurrently with the terminal's other methods.
 func (v *Terminal) Write(p []byte) (int, error) {
+	v.lock()
+	defer v.unlock()
 	if trace != nil {
 		_, _ = trace.Write(p)
 	}
-	return v.Decoder.Write(p)
+	return v.decoder.Write(p)
+}
+
+// WriteByte writes a single byte of input to the terminal. Like Write, it is
+// safe to call concurrently with the terminal's other methods.
+func (v *Terminal) WriteByte(c byte) error {
+	v.lock()
+	defer v.unlock()
+	if trace != nil {
+		_, _ = trace.Write([]byte{c})
+	}
+	return v.decoder.WriteByte(c)
+}
+
+// lock locks the terminal, queueing hook calls and responses until unlock.
+func (v *Terminal) lock() {
+	v.mut.Lock()
+	v.queueing = true
+}
+
+// unlock unlocks the terminal and then runs the queued hook calls and
+// responses, in order.
+func (v *Terminal) unlock() {
+	queued := v.queued
+	v.queued, v.queueing = nil, false
+	v.mut.Unlock()
+	for _, f := range queued {
+		f()
+	}
+}
+
+// later calls f once the terminal is unlocked, or right away if it isn't
+// locked by lock.
+func (v *Terminal) later(f func()) {
+	if v.queueing {
+		v.queued = append(v.queued, f)
+	} else {
+		f()
+	}
+}
+
+// respond writes a response to a query to ForwardResponses, once the terminal
+// is unlocked.
+func (v *Terminal) respond(format string, a ...any) {
+	w, msg := v.ForwardResponses, fmt.Sprintf(format, a...)
+	v.later(func() {
+		_, _ = io.WriteString(w, msg)
+	})
 }
 
 func (v *Terminal) Reset() {
@@ -152,6 +305,15 @@ func (v *Terminal) Reset() {
 	defer v.mut.Unlock()
 	v.reset()
 	v.insertMode = false
+	v.Modes = InputModes{}
+	v.shell = nil
+	v.lines = nil
+	v.selection = nil
+	if v.rules != nil {
+		// the rows' Changes counters start over
+		v.rules.rows = nil
+	}
+	v.links = nil
 }
 
 func (v *Terminal) UsedHeight() int {
@@ -169,7 +331,7 @@ func (v *Terminal) UsedWidth() int {
 // Resize sets the terminal height and width to rows and cols and disables
 // auto-resizing on both axes.
 func (v *Terminal) Resize(rows, cols int) {
-	v.mut.Lock()
+	v.lock()
 	v.resize(rows, cols)
 
 	// disable auto-resize upon manually resizing. what's the point if the new
@@ -178,7 +340,7 @@ func (v *Terminal) Resize(rows, cols int) {
 	v.AutoResizeY = false
 
 	f := v.onResize
-	v.mut.Unlock()
+	v.unlock()
 	if f != nil {
 		f(rows, cols)
 	}
@@ -186,7 +348,7 @@ func (v *Terminal) Resize(rows, cols int) {
 
 // Resize sets the terminal width to cols and disables auto-resizing width.
 func (v *Terminal) ResizeX(cols int) {
-	v.mut.Lock()
+	v.lock()
 	v.resize(v.Height, cols)
 
 	// disable auto-resize upon manually resizing. what's the point if the new
@@ -194,7 +356,7 @@ func (v *Terminal) ResizeX(cols int) {
 	v.AutoResizeX = false
 
 	f := v.onResize
-	v.mut.Unlock()
+	v.unlock()
 	if f != nil {
 		f(v.Height, cols)
 	}
@@ -203,7 +365,7 @@ func (v *Terminal) ResizeX(cols int) {
 // Resize sets the terminal height to rows rows and disables auto-resizing
 // height.
 func (v *Terminal) ResizeY(rows int) {
-	v.mut.Lock()
+	v.lock()
 	v.resize(rows, v.Width)
 
 	// disable auto-resize upon manually resizing. what's the point if the new
@@ -211,7 +373,7 @@ func (v *Terminal) ResizeY(rows int) {
 	v.AutoResizeY = false
 
 	f := v.onResize
-	v.mut.Unlock()
+	v.unlock()
 	if f != nil {
 		f(rows, v.Width)
 	}
@@ -222,8 +384,8 @@ type OnScrollbackFunc func(line Line)
 // OnScrollback sets a hook called for each line pushed into scrollback, i.e.
 // scrolled off the top of the main screen. It does not fire on the alternate
 // screen (which has no scrollback) or for scrolls confined to a bounded scroll
-// region. The hook runs synchronously while input is being processed and must
-// not re-enter the terminal (e.g. Write, Resize).
+// region. The hook is called once the Write or Resize which scrolled the line
+// off has finished, so it may use the terminal.
 func (v *Terminal) OnScrollback(f OnScrollbackFunc) {
 	v.mut.Lock()
 	v.onScrollback = f
@@ -232,33 +394,55 @@ func (v *Terminal) OnScrollback(f OnScrollbackFunc) {
 
 type OnResizeFunc func(rows, cols int)
 
-// OnResize sets a hook that is called every time the terminal resizes.
+// SetForwardResponses sets ForwardResponses, which is safe to do while the
+// terminal is being written to from another goroutine.
+func (v *Terminal) SetForwardResponses(w io.Writer) {
+	v.mut.Lock()
+	v.ForwardResponses = w
+	v.mut.Unlock()
+}
+
+// OnResize sets a hook that is called every time the terminal resizes. A nil
+// hook removes it.
 func (v *Terminal) OnResize(f OnResizeFunc) {
-	f(v.Height, v.Width)
+	if f != nil {
+		f(v.Height, v.Width)
+	}
 	v.mut.Lock()
 	v.onResize = f
 	v.mut.Unlock()
 }
 
 func (v *Terminal) resize(h, w int) {
-	v.Screen.resize(h, w)
-	if v.Alt != nil {
-		v.Alt.resize(h, w)
+	main, alt := v.Screen, v.Alt
+	if v.IsAlt {
+		main, alt = alt, main
+	}
+	main.resizeY(h)
+	if w != main.Width && main.Width > 0 && w > 0 && !v.AutoResizeX {
+		// only the main screen is reflowed, like other terminals; full-screen
+		// applications on the alt screen redraw themselves anyway
+		v.reflow(main, w)
+	} else {
+		main.resizeX(w)
 	}
+	if alt != nil {
+		alt.resize(h, w)
+	}
+	v.damagedAll()
 }
 
 // put puts r onto the current cursor's position, then advances the cursor.
 func (v *Terminal) put(r rune) {
 	if v.wrap {
-		v.Cursor.X = 0
-		v.moveDown()
-		v.wrap = false
+		v.softWrap()
 	}
 	x, y, f := v.Cursor.X, v.Cursor.Y, v.Cursor.F
 	if v.insertMode {
 		v.insertCharacters(1)
 	}
 	v.paint(y, x, f, r)
+	v.Info[y].Written = time.Now()
 	if y > v.MaxY {
 		v.MaxY = y
 	}
@@ -268,6 +452,18 @@ func (v *Terminal) put(r rune) {
 	v.advance()
 }
 
+// softWrap continues onto the next row after printing past the end of the
+// current one, marking the row as wrapped.
+func (v *Terminal) softWrap() {
+	// the cursor may be left below the bottom row by shrinking the height
+	if v.Cursor.Y < len(v.Info) {
+		v.Info[v.Cursor.Y].Wrapped = true
+	}
+	v.Cursor.X = 0
+	v.moveDown()
+	v.wrap = false
+}
+
 // advance advances the cursor, wrapping to the next line if need be.
 func (v *Terminal) advance() {
 	if !v.AutoResizeX && v.Cursor.X == v.Width-1 {
@@ -279,8 +475,13 @@ func (v *Terminal) advance() {
 }
 
 func (v *Terminal) swapAlt() {
+	// a selection belongs to the screen it was made on
+	v.selection = nil
 	v.IsAlt = !v.IsAlt
 	v.Screen, v.Alt = v.Alt, v.Screen
+	// damage is tracked for whichever screen is shown
+	v.Screen.damage, v.Alt.damage = v.Alt.damage, nil
+	v.damagedAll()
 }
 
 func scrollUp[T any](arr [][]T, positions, start, end int, empty T) {
@@ -492,14 +693,14 @@ func insertEmpties[T any](arr [][]T, row, col, ps int, empty T) {
 func (v *Terminal) insertCharacters(n int) {
 	insertEmpties(v.Content, v.Cursor.Y, v.Cursor.X, n, ' ')
 	v.Format.Insert(v.Cursor.Y, v.Cursor.X, v.Cursor.F, n)
-	v.changed(v.Cursor.Y, false)
+	v.changedCols(v.Cursor.Y, v.Cursor.X, v.Width)
 }
 
 func (v *Terminal) deleteCharacters(n int) {
 	v.wrap = false // delete characters resets the wrap state.
 	deleteCharacters(v.Content, v.Cursor.Y, v.Cursor.X, n, ' ')
 	v.Format.Delete(v.Cursor.Y, v.Cursor.X, n)
-	v.changed(v.Cursor.Y, false)
+	v.changedCols(v.Cursor.Y, v.Cursor.X, v.Width)
 }
 
 func (v *Terminal) eraseCharacters(n int) {
@@ -508,7 +709,7 @@ func (v *Terminal) eraseCharacters(n int) {
 	for i := 0; i < n; i++ {
 		v.Format.Paint(v.Cursor.Y, v.Cursor.X+i, v.Cursor.F)
 	}
-	v.changed(v.Cursor.Y, false)
+	v.changedCols(v.Cursor.Y, v.Cursor.X, v.Cursor.X+n)
 }
 
 func (v *Terminal) insertLines(n int) {
@@ -525,6 +726,10 @@ func (v *Terminal) insertLines(n int) {
 	insertLinesShallow(v.Changes, v.Cursor.Y, n, start, end, func() uint64 {
 		return 1
 	})
+	insertLinesShallow(v.Info, v.Cursor.Y, n, start, end, func() LineInfo {
+		return LineInfo{}
+	})
+	v.rowsShifted(v.Cursor.Y, end, n, false)
 }
 
 func (v *Terminal) deleteLines(n int) {
@@ -540,6 +745,10 @@ func (v *Terminal) deleteLines(n int) {
 	deleteLinesShallow(v.Changes, v.Cursor.Y, n, start, end, func() uint64 {
 		return 1
 	})
+	deleteLinesShallow(v.Info, v.Cursor.Y, n, start, end, func() LineInfo {
+		return LineInfo{}
+	})
+	v.rowsShifted(v.Cursor.Y, end, -n, false)
 }
 
 func (v *Terminal) scrollDownN(n int) {
@@ -552,27 +761,26 @@ func (v *Terminal) scrollDownN(n int) {
 	scrollDownShallow(v.Changes, n, start, end, func() uint64 {
 		return 1
 	})
+	scrollDownShallow(v.Info, n, start, end, func() LineInfo {
+		return LineInfo{}
+	})
+	v.rowsShifted(start, end, n, false)
 }
 
 func (v *Terminal) scrollUpN(n int) {
 	start, end := v.scrollRegion()
 	// only a full-screen scroll of the main screen produces scrollback
+	scrollback := !v.IsAlt && start == 0 && end == v.Height-1
 	var evicted []Line
-	if v.onScrollback != nil && !v.IsAlt && start == 0 && end == v.Height-1 {
+	if scrollback && (v.onScrollback != nil || v.Scrollback != nil) {
 		for i := 0; i < n && i < len(v.Content); i++ {
 			// snapshot before scrollUp recycles the row
-			content := append([]rune(nil), v.Content[i]...)
-			format := make([]Format, len(content))
-			col := 0
-			for r := range v.Format.Regions(i) {
-				for j := 0; j < r.Size && col < len(format); j++ {
-					format[col] = r.F
-					col++
-				}
-			}
-			evicted = append(evicted, Line{Content: content, Format: format})
+			evicted = append(evicted, v.line(i))
 		}
 	}
+	if scrollback {
+		v.searchScrollingOff(n)
+	}
 	// v.wrap = false // scroll up does NOT reset the wrap state.
 	scrollUp(v.Content, n, start, end, ' ')
 	scrollUpShallow(v.Format.Rows, n, start, end, func() *Region {
@@ -581,9 +789,53 @@ func (v *Terminal) scrollUpN(n int) {
 	scrollUpShallow(v.Changes, n, start, end, func() uint64 {
 		return 1
 	})
+	scrollUpShallow(v.Info, n, start, end, func() LineInfo {
+		return LineInfo{}
+	})
+	v.rowsShifted(start, end, -n, scrollback)
 	// deliver from the stable post-scroll state
 	for _, line := range evicted {
-		v.onScrollback(line)
+		v.pushScrollback(line)
+	}
+	if scrollback {
+		v.pruneBlocks()
+	}
+}
+
+// rowsShifted is called after the rows from start through end have moved by
+// delta rows, so that state anchored to rows can follow its content. Rows moved
+// outside of the range have been discarded, or pushed into scrollback if
+// scrollback is true.
+func (v *Terminal) rowsShifted(start, end, delta int, scrollback bool) {
+	v.shiftImages(start, end, delta)
+	v.shiftSearch(start, end, delta, scrollback)
+	v.shiftRules(start, end, delta)
+	v.shiftLinks(start, end, delta)
+	v.damagedRows(start, end)
+	if scrollback {
+		// count the lines instead, so that the line numbers of everything
+		// else stay the same
+		if v.lines != nil {
+			v.lines.scrolled -= delta
+		}
+		return
+	}
+	if v.shell != nil && !v.IsAlt {
+		v.shiftPositions(start, end, delta, v.shell.positions())
+	}
+	if v.selection != nil {
+		v.shiftPositions(start, end, delta, v.selection.positions())
+	}
+}
+
+// pushScrollback stores a line scrolled off the top of the main screen and
+// passes it to the OnScrollback hook.
+func (v *Terminal) pushScrollback(line Line) {
+	if v.Scrollback != nil {
+		v.Scrollback.push(line)
+	}
+	if f := v.onScrollback; f != nil {
+		v.later(func() { f(line) })
 	}
 }
 
@@ -622,6 +874,10 @@ func (v *Terminal) eraseRegion(y1, x1, y2, x2 int) {
 		for x := x1; x <= rowX2; x++ {
 			v.clear(y, x, f)
 		}
+		if rowX2 >= len(v.Content[y])-1 {
+			// nothing continues past an erased end of the row
+			v.Info[y].Wrapped = false
+		}
 	}
 }
 
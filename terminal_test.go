@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/danielgatis/go-ansicode"
 	"github.com/sebdah/goldie/v2"
@@ -291,6 +292,19 @@ func TestResizeNarrowThenRedrawDoesNotPanic(t *testing.T) {
 	require.NoError(t, vt.Render(buf))
 }
 
+func TestResizeShorterWithPendingWrapDoesNotPanic(t *testing.T) {
+	vt := midterm.NewTerminal(3, 4)
+	mustFprintf(t, vt, "\n\nabcd")
+
+	// shrinking the height alone leaves the cursor below the bottom row,
+	// with a wrap pending
+	vt.Resize(2, 4)
+	mustFprintf(t, vt, "xyz")
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, vt.Render(buf))
+}
+
 // TestOnScrollback verifies the OnScrollback hook fires for each line
 // pushed off the top of the main screen - in order, with its content and
 // formatting - and stays silent on the alt screen, which has no
@@ -492,3 +506,222 @@ func eachNthFrame(r io.Reader, n int, callback func(frame int, segment []byte))
 		maybeCall()
 	}
 }
+
+func TestHooksMayUseTheTerminal(t *testing.T) {
+	vt := midterm.NewTerminal(2, 10)
+	vt.VisualBell = true
+
+	var flashing bool
+	vt.OnBell(func() { flashing = vt.Flashing() })
+	var heights []int
+	vt.OnScrollback(func(midterm.Line) { heights = append(heights, vt.UsedHeight()) })
+	var rendered bytes.Buffer
+	vt.ForwardResponses = writerFunc(func(p []byte) (int, error) {
+		return len(p), vt.Render(&rendered)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = vt.Write([]byte("a\r\nb\r\nc\a\x1b[6n"))
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("deadlocked")
+	}
+	require.True(t, flashing)
+	require.Equal(t, []int{2}, heights)
+	require.Contains(t, rendered.String(), "c")
+}
+
+// writerFunc is an io.Writer implemented by a function.
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+func TestBell(t *testing.T) {
+	t.Run("counts bells and calls the hook", func(t *testing.T) {
+		vt := midterm.NewTerminal(2, 10)
+
+		var calls int
+		vt.OnBell(func() { calls++ })
+
+		// the BEL terminating the OSC is not a bell
+		mustFprintf(t, vt, "ding\a\x1b]2;title\a\a")
+
+		require.Equal(t, 2, vt.Bells)
+		require.Equal(t, 2, calls)
+		require.False(t, vt.LastBell.IsZero())
+		require.False(t, vt.Flashing(), "visual bell is disabled")
+	})
+
+	t.Run("flashes the screen with the visual bell", func(t *testing.T) {
+		vt := midterm.NewTerminal(1, 4)
+		vt.VisualBell = true
+		mustFprintf(t, vt, "AB\a")
+		require.True(t, vt.Flashing())
+
+		var buf bytes.Buffer
+		require.NoError(t, vt.Render(&buf))
+		require.Contains(t, buf.String(), "\x1b[7mAB")
+		require.Contains(t, vt.HTML(), "filter:invert(1);")
+
+		vt.LastBell = vt.LastBell.Add(-midterm.VisualBellDuration)
+		require.False(t, vt.Flashing())
+
+		buf.Reset()
+		require.NoError(t, vt.Render(&buf))
+		require.NotContains(t, buf.String(), "\x1b[7m")
+		require.NotContains(t, vt.HTML(), "filter:invert(1);")
+	})
+}
+
+func TestReflow(t *testing.T) {
+	// rows returns the screen's rows with trailing spaces trimmed
+	rows := func(vt *midterm.Terminal) []string {
+		var out []string
+		for _, row := range vt.Content {
+			out = append(out, strings.TrimRight(string(row), " "))
+		}
+		return out
+	}
+
+	t.Run("rewraps soft-wrapped lines", func(t *testing.T) {
+		vt := midterm.NewTerminal(5, 10)
+		mustFprintf(t, vt, "0123456789abcde\r\nxy")
+		require.Equal(t, []string{"0123456789", "abcde", "xy", "", ""}, rows(vt))
+
+		vt.Resize(5, 5)
+		require.Equal(t, []string{"01234", "56789", "abcde", "xy", ""}, rows(vt))
+		require.Equal(t, 3, vt.Cursor.Y)
+		require.Equal(t, 2, vt.Cursor.X)
+
+		vt.Resize(5, 20)
+		require.Equal(t, []string{"0123456789abcde", "xy", "", "", ""}, rows(vt))
+		require.Equal(t, 1, vt.Cursor.Y)
+		require.Equal(t, 2, vt.Cursor.X)
+
+		// the original text comes back at the original width
+		vt.Resize(5, 10)
+		require.Equal(t, []string{"0123456789", "abcde", "xy", "", ""}, rows(vt))
+	})
+
+	t.Run("preserves formatting", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 4)
+		mustFprintf(t, vt, "ab\x1b[1mcdef\x1b[0m")
+
+		vt.Resize(3, 3)
+		require.Equal(t, []string{"abc", "def", ""}, rows(vt))
+		var regions []string
+		for region := range vt.Format.Regions(1) {
+			regions = append(regions, fmt.Sprintf("%v:%d", region.F.IsBold(), region.Size))
+		}
+		require.Equal(t, []string{"true:3"}, regions)
+	})
+
+	t.Run("scrolls lines that no longer fit into scrollback", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 6)
+		var scrollback []string
+		vt.OnScrollback(func(line midterm.Line) {
+			scrollback = append(scrollback, string(line.Content))
+		})
+		mustFprintf(t, vt, "hello world\r\n$ ")
+
+		vt.Resize(3, 3)
+		require.Equal(t, []string{"hel", "lo "}, scrollback)
+		require.Equal(t, []string{"wor", "ld", "$"}, rows(vt))
+		require.Equal(t, 2, vt.Cursor.Y)
+		require.Equal(t, 2, vt.Cursor.X)
+	})
+
+	t.Run("keeps a pending wrap at the end of a row", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 4)
+		mustFprintf(t, vt, "abcdef")
+
+		// widening leaves room on the row, so the next character follows
+		vt.Resize(3, 8)
+		mustFprintf(t, vt, "gh")
+		require.Equal(t, []string{"abcdefgh", "", ""}, rows(vt))
+
+		// narrowing to a multiple of the length leaves it waiting to wrap
+		vt.Resize(3, 4)
+		mustFprintf(t, vt, "i")
+		require.Equal(t, []string{"abcd", "efgh", "i"}, rows(vt))
+	})
+
+	t.Run("does not reflow the alt screen", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 6)
+		mustFprintf(t, vt, "main screen\x1b[?1049halt screen")
+
+		vt.Resize(3, 3)
+		require.Equal(t, []string{"alt", "ree", ""}, rows(vt))
+
+		mustFprintf(t, vt, "\x1b[?1049l")
+		require.Equal(t, []string{"n s", "cre", "en"}, rows(vt))
+	})
+}
+
+func TestLineInfo(t *testing.T) {
+	t.Run("tracks soft wraps and writes", func(t *testing.T) {
+		vt := midterm.NewTerminal(4, 5)
+		before := time.Now()
+		mustFprintf(t, vt, "abcdefg\r\nhi")
+
+		require.True(t, vt.Info[0].Wrapped)
+		require.False(t, vt.Info[1].Wrapped)
+		require.False(t, vt.Info[2].Wrapped)
+		require.False(t, vt.Info[0].Written.Before(before))
+		require.False(t, vt.Info[2].Written.IsZero())
+		require.True(t, vt.Info[3].Written.IsZero())
+
+		// clearing the row means it no longer continues
+		mustFprintf(t, vt, "\x1b[1;1H\x1b[2K")
+		require.False(t, vt.Info[0].Wrapped)
+	})
+
+	t.Run("moves tags along with their rows", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 10)
+		var scrollback []midterm.Line
+		vt.OnScrollback(func(line midterm.Line) {
+			scrollback = append(scrollback, line)
+		})
+
+		mustFprintf(t, vt, "one\r\ntwo\r\nthree")
+		vt.SetLineTag(0, "source", "stdout")
+		vt.SetLineTag(1, "source", "stderr")
+		vt.SetLineTag(1, "unused", "x")
+		vt.SetLineTag(1, "unused", "")
+
+		// insert a line above "two"
+		mustFprintf(t, vt, "\x1b[2;1H\x1b[L")
+		require.Nil(t, vt.Info[1].Tags)
+		require.Equal(t, map[string]string{"source": "stderr"}, vt.Info[2].Tags)
+
+		// scroll "one" off the top
+		mustFprintf(t, vt, "\x1b[3;1H\n")
+		require.Len(t, scrollback, 1)
+		require.Equal(t, "one", strings.TrimRight(string(scrollback[0].Content), " "))
+		require.Equal(t, map[string]string{"source": "stdout"}, scrollback[0].Info.Tags)
+		require.False(t, scrollback[0].Info.Written.IsZero())
+		require.Equal(t, map[string]string{"source": "stderr"}, vt.Info[1].Tags)
+	})
+}
+
+func TestInputModes(t *testing.T) {
+	vt := midterm.NewTerminal(3, 10)
+	mustFprintf(t, vt, "\x1b[?1h\x1b[?1002h\x1b[?1006h\x1b[?2004h")
+	require.Equal(t, midterm.InputModes{
+		AppCursorKeys:  true,
+		Mouse:          midterm.MouseTrackingDrag,
+		SGRMouse:       true,
+		BracketedPaste: true,
+	}, vt.Modes)
+
+	// unsetting another mouse mode leaves tracking on
+	mustFprintf(t, vt, "\x1b[?1000l")
+	require.Equal(t, midterm.MouseTrackingDrag, vt.Modes.Mouse)
+
+	mustFprintf(t, vt, "\x1b[?1l\x1b[?1002l\x1b[?2004l")
+	require.Equal(t, midterm.InputModes{SGRMouse: true}, vt.Modes)
+}
@@ -0,0 +1,202 @@
+package midterm
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/muesli/termenv"
+)
+
+// Viewport is a window of rows onto the terminal's history, which is its
+// Scrollback followed by the rows of the screen. Rows are numbered from the
+// oldest line in the scrollback.
+//
+// A new Viewport follows the tail of the history, showing the bottom of the
+// screen as output arrives, until it is scrolled up. Scrolling back to the
+// bottom resumes following.
+//
+// The alternate screen has no history, so only its rows are shown while it is
+// active.
+type Viewport struct {
+	vt *Terminal
+
+	// Height is the number of rows shown.
+	Height int
+
+	// follow indicates that the viewport sticks to the bottom.
+	follow bool
+
+	// top is the first row shown when not following, offset by the number of
+	// lines dropped from the scrollback so that it stays on the same line as
+	// the scrollback is trimmed.
+	top int
+}
+
+// NewViewport returns a Viewport showing height rows, following the tail.
+func (v *Terminal) NewViewport(height int) *Viewport {
+	return &Viewport{vt: v, Height: height, follow: true}
+}
+
+// Following reports whether the viewport is following the tail.
+func (vp *Viewport) Following() bool {
+	vp.vt.mut.Lock()
+	defer vp.vt.mut.Unlock()
+	return vp.following()
+}
+
+// Offset returns the first row shown.
+func (vp *Viewport) Offset() int {
+	vp.vt.mut.Lock()
+	defer vp.vt.mut.Unlock()
+	return vp.offset()
+}
+
+// Rows returns the number of rows in the history.
+func (vp *Viewport) Rows() int {
+	vp.vt.mut.Lock()
+	defer vp.vt.mut.Unlock()
+	return vp.rows()
+}
+
+// ScrollTo shows rows starting from offset, following the tail if that
+// reaches the bottom.
+func (vp *Viewport) ScrollTo(offset int) {
+	vp.vt.mut.Lock()
+	defer vp.vt.mut.Unlock()
+	vp.scrollTo(offset)
+}
+
+// ScrollUp scrolls up by n rows, towards older lines.
+func (vp *Viewport) ScrollUp(n int) {
+	vp.vt.mut.Lock()
+	defer vp.vt.mut.Unlock()
+	vp.scrollTo(vp.offset() - n)
+}
+
+// ScrollDown scrolls down by n rows, towards the screen.
+func (vp *Viewport) ScrollDown(n int) {
+	vp.vt.mut.Lock()
+	defer vp.vt.mut.Unlock()
+	vp.scrollTo(vp.offset() + n)
+}
+
+// ScrollToTop shows the oldest rows.
+func (vp *Viewport) ScrollToTop() {
+	vp.ScrollTo(0)
+}
+
+// ScrollToBottom shows the bottom of the screen and resumes following.
+func (vp *Viewport) ScrollToBottom() {
+	vp.vt.mut.Lock()
+	defer vp.vt.mut.Unlock()
+	vp.follow = true
+}
+
+// Position returns the position of a cell in a row of the viewport, which keeps
+// referring to the same content as the terminal scrolls.
+func (vp *Viewport) Position(row, col int) Position {
+	vp.vt.mut.Lock()
+	defer vp.vt.mut.Unlock()
+	vp.vt.trackLines()
+	return vp.vt.position(vp.offset()+row-vp.scrollback(), col)
+}
+
+// Render writes the rows of the viewport to w, separated by newlines.
+func (vp *Viewport) Render(w io.Writer) error {
+	return vp.RenderFgBg(w, nil, nil)
+}
+
+// RenderFgBg is like Render, using fg and bg for cells with no color of their
+// own.
+func (vp *Viewport) RenderFgBg(w io.Writer, fg, bg termenv.Color) error {
+	vp.vt.mut.Lock()
+	defer vp.vt.mut.Unlock()
+	for row := range vp.Height {
+		if row > 0 {
+			if _, err := fmt.Fprintln(w); err != nil {
+				return err
+			}
+		}
+		if err := vp.renderLine(w, row, fg, bg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenderLine writes a row of the viewport to w.
+func (vp *Viewport) RenderLine(w io.Writer, row int) error {
+	return vp.RenderLineFgBg(w, row, nil, nil)
+}
+
+// RenderLineFgBg is like RenderLine, using fg and bg for cells with no color
+// of their own.
+func (vp *Viewport) RenderLineFgBg(w io.Writer, row int, fg, bg termenv.Color) error {
+	vp.vt.mut.Lock()
+	defer vp.vt.mut.Unlock()
+	return vp.renderLine(w, row, fg, bg)
+}
+
+func (vp *Viewport) renderLine(w io.Writer, row int, fg, bg termenv.Color) error {
+	vt := vp.vt
+	row += vp.offset()
+	scrollback := vp.scrollback()
+	switch {
+	case row < scrollback:
+		line := vt.ruleHighlightLine(vt.Scrollback.Line(row))
+		line = vt.searchHighlightLine(row-scrollback, line)
+		line = vt.selectLine(vt.position(row-scrollback, 0).Line, line)
+		return renderCells(w, line, vt.Width, fg, bg)
+	case row-scrollback < vt.Height:
+		return vt.renderLine(w, row-scrollback, fg, bg)
+	default:
+		// past the end of the history
+		return renderCells(w, Line{}, vt.Width, fg, bg)
+	}
+}
+
+// scrollback returns the number of rows of scrollback in the history.
+func (vp *Viewport) scrollback() int {
+	if vp.vt.Scrollback == nil || vp.vt.IsAlt {
+		return 0
+	}
+	return vp.vt.Scrollback.Len()
+}
+
+// dropped returns the number of lines dropped from the scrollback.
+func (vp *Viewport) dropped() int {
+	if vp.vt.Scrollback == nil {
+		return 0
+	}
+	return vp.vt.Scrollback.dropped
+}
+
+func (vp *Viewport) rows() int {
+	return vp.scrollback() + vp.vt.Height
+}
+
+// bottom returns the offset at which the viewport shows the bottom row.
+func (vp *Viewport) bottom() int {
+	return max(vp.rows()-vp.Height, 0)
+}
+
+func (vp *Viewport) following() bool {
+	return vp.follow || vp.top-vp.dropped() >= vp.bottom()
+}
+
+func (vp *Viewport) offset() int {
+	if vp.following() || vp.vt.IsAlt {
+		return vp.bottom()
+	}
+	return max(vp.top-vp.dropped(), 0)
+}
+
+func (vp *Viewport) scrollTo(offset int) {
+	offset = max(offset, 0)
+	if offset >= vp.bottom() {
+		vp.follow = true
+		return
+	}
+	vp.follow = false
+	vp.top = offset + vp.dropped()
+}
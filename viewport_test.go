@@ -0,0 +1,86 @@
+package midterm_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vito/midterm"
+)
+
+func TestViewport(t *testing.T) {
+	// render returns the plain text of each row of the viewport
+	render := func(t *testing.T, vp *midterm.Viewport) []string {
+		t.Helper()
+		var buf strings.Builder
+		require.NoError(t, vp.Render(&buf))
+		var rows []string
+		for _, row := range strings.Split(buf.String(), "\n") {
+			row = strings.ReplaceAll(row, "\x1b[0m", "")
+			rows = append(rows, strings.TrimRight(row, " "))
+		}
+		return rows
+	}
+
+	t.Run("follows the tail until scrolled up", func(t *testing.T) {
+		vt := midterm.NewTerminal(3, 10)
+		vt.Scrollback = &midterm.Scrollback{}
+		mustFprintf(t, vt, "1\r\n2\r\n3\r\n4\r\n5")
+
+		vp := vt.NewViewport(2)
+		require.True(t, vp.Following())
+		require.Equal(t, 5, vp.Rows())
+		require.Equal(t, []string{"4", "5"}, render(t, vp))
+
+		vp.ScrollUp(2)
+		require.False(t, vp.Following())
+		require.Equal(t, 1, vp.Offset())
+		require.Equal(t, []string{"2", "3"}, render(t, vp))
+
+		// new output doesn't move the view
+		mustFprintf(t, vt, "\r\n6\r\n7")
+		require.Equal(t, []string{"2", "3"}, render(t, vp))
+
+		// scrolling past the top stops at the oldest line
+		vp.ScrollUp(10)
+		require.Equal(t, 0, vp.Offset())
+		require.Equal(t, []string{"1", "2"}, render(t, vp))
+
+		// reaching the bottom follows the tail again
+		vp.ScrollDown(100)
+		require.True(t, vp.Following())
+		mustFprintf(t, vt, "\r\n8")
+		require.Equal(t, []string{"7", "8"}, render(t, vp))
+	})
+
+	t.Run("stays on its lines as the scrollback is trimmed", func(t *testing.T) {
+		vt := midterm.NewTerminal(2, 10)
+		vt.Scrollback = &midterm.Scrollback{MaxLines: 5}
+		mustFprintf(t, vt, "1\r\n2\r\n3\r\n4\r\n5")
+
+		vp := vt.NewViewport(2)
+		vp.ScrollTo(1)
+		require.Equal(t, []string{"2", "3"}, render(t, vp))
+
+		// "1" is dropped from the scrollback
+		mustFprintf(t, vt, "\r\n6\r\n7\r\n8")
+		require.Equal(t, []string{"2", "3"}, render(t, vp))
+		require.Equal(t, 0, vp.Offset())
+
+		// once its lines are gone, it shows the oldest
+		mustFprintf(t, vt, "\r\n9")
+		require.Equal(t, []string{"3", "4"}, render(t, vp))
+	})
+
+	t.Run("renders rows past the end as blank", func(t *testing.T) {
+		vt := midterm.NewTerminal(2, 4)
+		mustFprintf(t, vt, "ab\r\ncd")
+
+		vp := vt.NewViewport(3)
+		require.Equal(t, []string{"ab", "cd", ""}, render(t, vp))
+
+		var buf strings.Builder
+		require.NoError(t, vp.RenderLine(&buf, 2))
+		require.Equal(t, "    \x1b[0m", buf.String())
+	})
+}